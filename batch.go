@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sqlrepl/internal/database"
+)
+
+// maxBatchFailures is how many of a batch's failed executions \batch lists
+// before summing up the rest.
+const maxBatchFailures = 20
+
+// batch runs the statement in a file once for each set of parameters in a
+// CSV file, with no header row, or in a JSON file holding an array of arrays,
+// all in one transaction. The statement is written with the driver's own
+// placeholders, like $1 or ?, which are bound to a row's values in order. An
+// empty CSV value is bound as NULL.
+// Usage: \batch <statement file> <parameters file>
+func (r *repl) batch(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return fmt.Errorf("usage: \\batch <statement file> <parameters file>")
+	}
+
+	script, err := os.ReadFile(fields[0])
+	if err != nil {
+		return err
+	}
+	statements := database.SplitStatements(string(script), r.dbType())
+	if len(statements) != 1 {
+		return fmt.Errorf("%s must hold one statement, not %d", fields[0], len(statements))
+	}
+	params, rows, err := readBatchParams(fields[1])
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", fields[1], err)
+	}
+	if len(params) == 0 {
+		return fmt.Errorf("%s holds no parameters", fields[1])
+	}
+
+	inTransaction := r.conn.InTransaction()
+	affected, failures, err := r.conn.ExecuteBatch(statements[0], params)
+	if err != nil {
+		return err
+	}
+
+	for i, failure := range failures {
+		if i == maxBatchFailures {
+			fmt.Printf("... and %d more\n", len(failures)-maxBatchFailures)
+			break
+		}
+		fmt.Printf("Error: %s: %v\n", rows[failure.Index], failure.Err)
+	}
+	switch {
+	case len(failures) == 0:
+		fmt.Printf("Ran %d executions, changing %d rows.\n", len(params), affected)
+	case inTransaction:
+		fmt.Printf("%d of %d executions failed; commit or roll back the open transaction.\n", len(failures), len(params))
+	default:
+		fmt.Printf("%d of %d executions failed, so none were kept.\n", len(failures), len(params))
+	}
+	return nil
+}
+
+// readBatchParams reads the sets of parameters for \batch from a CSV file, or
+// from a JSON file if its name ends in .json. It returns them with where each
+// came from in the file, such as "line 3", for reporting failures.
+func readBatchParams(path string) ([][]any, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var params [][]any
+	var rows []string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var values [][]any
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err = decoder.Decode(&values); err != nil {
+			return nil, nil, err
+		}
+		for n, row := range values {
+			for i, value := range row {
+				if row[i], err = jsonParam(value); err != nil {
+					return nil, nil, fmt.Errorf("row %d: %w", n+1, err)
+				}
+			}
+			params = append(params, row)
+			rows = append(rows, fmt.Sprintf("row %d", n+1))
+		}
+		return params, rows, nil
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		row := make([]any, len(record))
+		for i, value := range record {
+			if value != "" {
+				row[i] = value
+			}
+		}
+		line, _ := reader.FieldPos(0)
+		params = append(params, row)
+		rows = append(rows, fmt.Sprintf("line %d", line))
+	}
+	return params, rows, nil
+}
+
+// jsonParam converts a value decoded from JSON into one a driver can bind.
+func jsonParam(value any) (any, error) {
+	switch value := value.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n, nil
+		}
+		return value.Float64()
+	case nil, string, bool:
+		return value, nil
+	}
+	return nil, fmt.Errorf("%v is not a string, number, boolean or null", value)
+}
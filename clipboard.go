@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardTool is a pair of commands that read and write the system
+// clipboard through stdout and stdin.
+type clipboardTool struct {
+	paste []string
+	copy  []string
+}
+
+// clipboardTools are the clipboard commands for each OS, in the order they're
+// tried.
+var clipboardTools = map[string][]clipboardTool{
+	"darwin": {
+		{paste: []string{"pbpaste"}, copy: []string{"pbcopy"}},
+	},
+	"windows": {
+		{
+			paste: []string{"powershell", "-NoProfile", "-Command", "Get-Clipboard"},
+			copy:  []string{"powershell", "-NoProfile", "-Command", "$input | Set-Clipboard"},
+		},
+	},
+}
+
+// unixClipboardTools are tried on every other OS: wl-clipboard under Wayland,
+// then the X11 tools.
+var unixClipboardTools = []clipboardTool{
+	{paste: []string{"xclip", "-selection", "clipboard", "-o"}, copy: []string{"xclip", "-selection", "clipboard", "-i"}},
+	{paste: []string{"xsel", "--clipboard", "--output"}, copy: []string{"xsel", "--clipboard", "--input"}},
+}
+
+// clipboardCommand returns whichever installed command pastes from, or if
+// copying, copies to, the system clipboard.
+func clipboardCommand(copying bool) ([]string, error) {
+	tools, ok := clipboardTools[runtime.GOOS]
+	if !ok {
+		tools = unixClipboardTools
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			wayland := clipboardTool{paste: []string{"wl-paste", "--no-newline"}, copy: []string{"wl-copy"}}
+			tools = append([]clipboardTool{wayland}, tools...)
+		}
+	}
+
+	var tried []string
+	for _, tool := range tools {
+		command := tool.paste
+		if copying {
+			command = tool.copy
+		}
+		if _, err := exec.LookPath(command[0]); err == nil {
+			return command, nil
+		}
+		tried = append(tried, command[0])
+	}
+	return nil, fmt.Errorf("no clipboard command found (tried %s)", strings.Join(tried, ", "))
+}
+
+// readClipboard returns the text on the system clipboard.
+func readClipboard() (string, error) {
+	command, err := clipboardCommand(false)
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.Command(command[0], command[1:]...).Output()
+	if err != nil {
+		return "", clipboardError(command[0], err)
+	}
+	return string(out), nil
+}
+
+// writeClipboard puts text on the system clipboard.
+func writeClipboard(text string) error {
+	command, err := clipboardCommand(true)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	if _, err := cmd.Output(); err != nil {
+		return clipboardError(command[0], err)
+	}
+	return nil
+}
+
+// clipboardError describes a failed clipboard command, using what it printed
+// to stderr if anything.
+func clipboardError(name string, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%s: %s", name, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
@@ -0,0 +1,1619 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/message"
+
+	"sqlrepl/internal/client"
+	"sqlrepl/internal/database"
+	"sqlrepl/internal/protocol"
+	"sqlrepl/internal/querylog"
+)
+
+// settings are the options that control how a session prints results and
+// runs queries, changed with commands like \pset and \format.
+type settings struct {
+	// format names the formatter used to print results
+	format string
+
+	// border is the table border style, see writeTable
+	border int
+
+	// trim tidies whitespace in displayed values, see trimValues
+	trim bool
+
+	// insertTable is the table named by the insert output format
+	insertTable string
+
+	// safe requires confirmation before running destructive statements
+	safe bool
+
+	// columnFormats maps column names to the \fmt rule applied to their
+	// values when printing
+	columnFormats map[string]string
+
+	// tuplesOnly prints single-column results in the table format as bare
+	// values, one per line
+	tuplesOnly bool
+
+	// columnOrder names the columns to print first, see reorderColumns
+	columnOrder []string
+
+	// jsonTyped writes numbers and booleans as such in the json format,
+	// see writeJSON
+	jsonTyped bool
+
+	// displayLimit is the most rows of a result printed, or zero for all of
+	// them. The rest are still fetched and counted.
+	displayLimit int
+
+	// pageSize is how many rows of a result are printed before asking
+	// whether to fetch more, or zero to print them all, see runPaged
+	pageSize int
+
+	// promptFormat is the template the prompt is rendered from, see
+	// renderPrompt; empty for the default prompt
+	promptFormat string
+
+	// fixedWidths are the byte widths of the columns in the fixed format,
+	// see writeFixed
+	fixedWidths []int
+
+	// timingBreakdown prints how long each query spent running in the
+	// database and fetching its rows
+	timingBreakdown bool
+
+	// numbers writes numeric columns in the display formats in the style of
+	// the -locale, or is nil to leave them as they are
+	numbers *message.Printer
+}
+
+// defaultSettings returns the settings a session starts with, taken from the
+// command-line flags.
+func defaultSettings() settings {
+	return settings{
+		format:        *outputFormat,
+		border:        *border,
+		trim:          *trimWhitespace,
+		insertTable:   *insertTable,
+		safe:          *safeMode,
+		columnFormats: map[string]string{},
+		tuplesOnly:    *tuplesOnly,
+		jsonTyped:     *jsonTyped,
+		promptFormat:  *promptFormat,
+		pageSize:      *pageSize,
+		numbers:       localePrinter(*numberLocale),
+	}
+}
+
+// repl holds the state of an interactive session.
+type repl struct {
+	settings
+
+	conn       *database.Connection
+	input      *bufio.Scanner
+	lastResult *protocol.QueryResult
+
+	// buffer holds the lines typed so far of a statement that isn't
+	// finished, see IsIncomplete
+	buffer []string
+
+	// conns are the open connections by name: the one the session started
+	// with, as mainConnection, and those opened with \open. connName names
+	// the one in conn, which queries run on unless they name another.
+	conns    map[string]*database.Connection
+	connName string
+
+	// lastConn is the connection the last query was sent to
+	lastConn *database.Connection
+
+	// output is where query results are written, changed with \o
+	output     io.Writer
+	outputFile *os.File
+
+	// vars are substituted for :name in queries
+	vars map[string]string
+
+	// lastError and errorStreak track how many queries in a row have
+	// failed with the same error
+	lastError   string
+	errorStreak int
+
+	// local holds results copied with \materialize, opened on first use
+	local *database.LocalStore
+
+	// schema is the current schema, shown in the prompt
+	schema string
+
+	// queryLog records the statements run, may be nil
+	queryLog *querylog.Logger
+
+	// recorder records the queries run and their results for -replay, may
+	// be nil
+	recorder *sessionRecorder
+
+	// remote, when connected to a sqlrepl server with -connect, is what
+	// queries are sent to in place of conn, which is nil. remoteType is
+	// the type of the database the server opened.
+	remote     *client.Session
+	remoteType int
+
+	// jobs are the background queries that haven't been waited for, by id
+	jobs    map[int]*job
+	nextJob int
+}
+
+// newRepl starts a session on conn, reading input from input, with the
+// default settings.
+func newRepl(conn *database.Connection, input *bufio.Scanner) *repl {
+	r := &repl{
+		settings: defaultSettings(),
+		conn:     conn,
+		input:    input,
+		output:   os.Stdout,
+		vars:     map[string]string{},
+	}
+	// the prompt just goes without the schema if it can't be found, or
+	// with -no-ping, since the database may not be reachable yet
+	if conn != nil {
+		r.conns = map[string]*database.Connection{mainConnection: conn}
+		r.connName = mainConnection
+		if !conn.Options.NoPing {
+			r.schema, _ = conn.CurrentSchema()
+		}
+	}
+	return r
+}
+
+// mainConnection is the name of the connection the session started with.
+const mainConnection = "main"
+
+// prompt returns the prompt shown before each line of input, rendered from
+// the prompt template if there is one. Otherwise once other connections are
+// open it starts with the name of the current one.
+func (r *repl) prompt() string {
+	if r.promptFormat != "" {
+		return r.renderPrompt(r.promptFormat)
+	}
+	prompt := r.schema
+	if len(r.conns) > 1 {
+		prompt = strings.TrimSuffix(r.connName+":"+r.schema, ":")
+	}
+	return prompt + "> "
+}
+
+// abort discards the lines typed so far of an unfinished statement, going
+// back to the usual prompt. Usage: \abort, or \r
+func (r *repl) abort(args string) error {
+	if len(r.buffer) == 0 {
+		fmt.Println("No statement to discard.")
+		return nil
+	}
+	r.buffer = nil
+	fmt.Println("Statement discarded.")
+	return nil
+}
+
+// localPrefix routes a query to the local store rather than the database,
+// e.g. "local> SELECT count(*) FROM tmp"
+const localPrefix = "local>"
+
+// execute runs a query against the database, against the connection named by
+// a "name>" prefix, or against the local store if it's prefixed with
+// localPrefix.
+func (r *repl) execute(query string) *protocol.QueryResult {
+	return r.executeOn(r.connectionFor(query))
+}
+
+// executeOn runs a query like execute, on conn, which is nil when connected to
+// a server. A statement allowed turns down isn't run, and the result says so.
+func (r *repl) executeOn(conn *database.Connection, query string) *protocol.QueryResult {
+	if !r.allowed(conn, query) {
+		return &protocol.QueryResult{Error: errNotRun.Error()}
+	}
+	return r.executeUnchecked(conn, query)
+}
+
+// errNotRun is returned for a statement the user chose not to run.
+var errNotRun = errors.New("statement not run")
+
+// allowed reports whether query may run on conn. In safe mode a statement that
+// may destroy data is run only once the user confirms it, and with
+// -confirm-rows so is one that changes more rows than that.
+func (r *repl) allowed(conn *database.Connection, query string) bool {
+	if r.safe && database.IsDestructive(query) &&
+		!r.confirm("This statement may destroy data. Type YES to run it: ") {
+		return false
+	}
+	return *confirmRows <= 0 || r.checkRowCount(conn, query)
+}
+
+// executeUnchecked runs a query like executeOn, without asking allowed first,
+// for statements that have already been allowed or that only read.
+func (r *repl) executeUnchecked(conn *database.Connection, query string) *protocol.QueryResult {
+	// the connections log what they send themselves, see logQueries
+	if conn == nil || strings.HasPrefix(query, localPrefix) {
+		r.queryLog.Log("interactive", query)
+	}
+	if localQuery, ok := strings.CutPrefix(query, localPrefix); ok {
+		if r.local == nil {
+			return &protocol.QueryResult{Error: "nothing has been materialized yet"}
+		}
+		return r.local.Query(localQuery)
+	}
+	if r.remote != nil {
+		result, err := r.remote.Query(query)
+		if err != nil {
+			return &protocol.QueryResult{Error: err.Error()}
+		}
+		return result
+	}
+	r.lastConn = conn
+	return conn.ExecuteQuery(query)
+}
+
+// logQueries has conn write every statement it sends to the query log, from
+// whichever command it was run by.
+func (r *repl) logQueries(conn *database.Connection) {
+	if r.queryLog != nil {
+		conn.Options.OnQuery = func(query string) { r.queryLog.Log("interactive", query) }
+	}
+}
+
+// connectionFor returns the connection query should run on and the query
+// itself, without the "name>" prefix if it starts with the name of a
+// connection opened with \open.
+func (r *repl) connectionFor(query string) (*database.Connection, string) {
+	if name, rest, ok := strings.Cut(query, ">"); ok {
+		if conn, ok := r.conns[strings.TrimSpace(name)]; ok {
+			return conn, strings.TrimSpace(rest)
+		}
+	}
+	return r.conn, query
+}
+
+// dbType returns the type of the database queries are run against.
+func (r *repl) dbType() int {
+	if r.remote != nil {
+		return r.remoteType
+	}
+	return r.conn.DBType()
+}
+
+// reconnect opens the connection to the database, or to the server, again.
+func (r *repl) reconnect() error {
+	if r.remote != nil {
+		return r.remote.Reconnect()
+	}
+	return r.conn.Reconnect()
+}
+
+// close releases everything the session holds open: every connection it
+// opened, or the server session, background queries, and the output file.
+func (r *repl) close() {
+	r.cancelJobs()
+	r.closeOutput()
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+	if r.remote != nil {
+		r.remote.Close()
+	}
+	if r.local != nil {
+		r.local.Close()
+	}
+}
+
+// replCommand handles a backslash command entered at the prompt. args is the
+// rest of the line after the command name.
+type replCommand func(r *repl, args string) error
+
+var replCommands map[string]replCommand
+
+func init() {
+	replCommands = map[string]replCommand{
+		"abort":            (*repl).abort,
+		"async":            (*repl).async,
+		"batch":            (*repl).batch,
+		"begin":            (*repl).begin,
+		"call":             (*repl).call,
+		"cell":             (*repl).cell,
+		"checksum":         (*repl).checksum,
+		"cols":             (*repl).cols,
+		"commit":           (*repl).commit,
+		"compare":          (*repl).compare,
+		"dump-schema":      (*repl).dumpSchema,
+		"export":           (*repl).export,
+		"fmt":              (*repl).setColumnFormat,
+		"format":           (*repl).setFormat,
+		"grep-cols":        (*repl).grepColumns,
+		"grep-rows":        (*repl).grepRows,
+		"indexes":          (*repl).indexes,
+		"into":             (*repl).into,
+		"jobs":             (*repl).listJobs,
+		"json":             (*repl).prettyJSON,
+		"listen":           (*repl).listen,
+		"lob":              (*repl).lob,
+		"materialize":      (*repl).materialize,
+		"o":                (*repl).setOutput,
+		"open":             (*repl).open,
+		"paste":            (*repl).paste,
+		"pipe":             (*repl).pipe,
+		"plan":             (*repl).plan,
+		"profile":          (*repl).profile,
+		"pset":             (*repl).pset,
+		"queries":          (*repl).listQueries,
+		"r":                (*repl).abort,
+		"reorder":          (*repl).reorder,
+		"reset":            (*repl).reset,
+		"rollback":         (*repl).rollback,
+		"run":              (*repl).runSaved,
+		"safe":             (*repl).setSafe,
+		"save":             (*repl).save,
+		"set":              (*repl).set,
+		"sql":              (*repl).showSQL,
+		"stats":            (*repl).stats,
+		"switch":           (*repl).switchConnection,
+		"t":                (*repl).setTuplesOnly,
+		"timeout-once":     (*repl).timeoutOnce,
+		"timing-breakdown": (*repl).setTimingBreakdown,
+		"truncate":         (*repl).truncate,
+		"use":              (*repl).use,
+		"wait":             (*repl).wait,
+		"watch":            (*repl).watch,
+		"yank":             (*repl).yank,
+	}
+}
+
+// directCommands are the commands that use the database connection for more
+// than running queries, so can't be used through a server.
+var directCommands = map[string]bool{
+	"async":        true,
+	"batch":        true,
+	"begin":        true,
+	"call":         true,
+	"cols":         true,
+	"commit":       true,
+	"compare":      true,
+	"dump-schema":  true,
+	"indexes":      true,
+	"into":         true,
+	"listen":       true,
+	"lob":          true,
+	"materialize":  true,
+	"open":         true,
+	"plan":         true,
+	"rollback":     true,
+	"sql":          true,
+	"switch":       true,
+	"timeout-once": true,
+	"use":          true,
+}
+
+// confirm prints prompt and reports whether the user typed YES in reply.
+func (r *repl) confirm(prompt string) bool {
+	fmt.Print(prompt)
+	if !r.input.Scan() {
+		return false
+	}
+	return strings.TrimSpace(r.input.Text()) == "YES"
+}
+
+// runCommand parses a backslash command line and dispatches it to its handler.
+func (r *repl) runCommand(line string) error {
+	name, args, _ := strings.Cut(strings.TrimPrefix(line, `\`), " ")
+	command, ok := replCommands[name]
+	if !ok {
+		return fmt.Errorf("unknown command: \\%s", name)
+	}
+	if r.conn == nil && directCommands[name] {
+		return fmt.Errorf("\\%s needs a direct database connection, not a server", name)
+	}
+	return command(r, strings.TrimSpace(args))
+}
+
+// profile runs a query N times, discarding the results, and reports latency
+// statistics. Usage: \profile <n> <query>
+func (r *repl) profile(args string) error {
+	countArg, query, _ := strings.Cut(args, " ")
+	count, err := strconv.Atoi(countArg)
+	if err != nil || count < 1 || strings.TrimSpace(query) == "" {
+		return fmt.Errorf("usage: \\profile <n> <query>")
+	}
+
+	fmt.Println("Warning: \\profile runs the query repeatedly; only use it with idempotent SELECT statements")
+
+	conn, query := r.connectionFor(query)
+	if !r.allowed(conn, query) {
+		return errNotRun
+	}
+
+	durations := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		result := r.executeUnchecked(conn, query)
+		elapsed := time.Since(start)
+		if result.Error != "" {
+			return fmt.Errorf("run %d failed: %s", i+1, result.Error)
+		}
+		durations = append(durations, elapsed)
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	mean := total / time.Duration(len(durations))
+	p95 := durations[(len(durations)*95+99)/100-1]
+
+	fmt.Printf("runs: %d\tmin: %v\tmax: %v\tmean: %v\tp95: %v\n",
+		len(durations), durations[0], durations[len(durations)-1], mean, p95)
+	return nil
+}
+
+// listen prints postgres notifications sent on a channel as they arrive,
+// until interrupted with Ctrl+C. Usage: \listen <channel>
+func (r *repl) listen(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\listen <channel>")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return r.conn.Listen(ctx, args, func(notification database.Notification) {
+		fmt.Fprintf(r.output, "Notification on %s from process %d: %s\n",
+			notification.Channel, notification.PID, notification.Payload)
+	})
+}
+
+// timeoutOnce runs a query that may take up to the given duration, such as
+// 5m, rather than the usual limit. Later queries keep the usual limit.
+// Usage: \timeout-once <duration> <query>
+func (r *repl) timeoutOnce(args string) error {
+	value, query, _ := strings.Cut(args, " ")
+	query = strings.TrimSpace(query)
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 || query == "" {
+		return fmt.Errorf("usage: \\timeout-once <duration> <query>")
+	}
+	if strings.HasPrefix(query, localPrefix) {
+		return fmt.Errorf("local queries can't be given a timeout")
+	}
+	r.runQueryTimeout(query, timeout)
+	return nil
+}
+
+// paste runs the query on the system clipboard. Usage: \paste
+func (r *repl) paste(args string) error {
+	query, err := readClipboard()
+	if err != nil {
+		return err
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return fmt.Errorf("the clipboard is empty")
+	}
+
+	// show what's about to run, since it wasn't typed
+	fmt.Println(query)
+	r.runQuery(query)
+	return nil
+}
+
+// yank copies the last result to the system clipboard, as TSV unless another
+// format is given, ready to paste into a spreadsheet. Usage: \yank [format]
+func (r *repl) yank(args string) error {
+	if r.lastResult == nil {
+		return fmt.Errorf("no result to copy")
+	}
+	format := "tsv"
+	if args != "" {
+		format = args
+	}
+	formatter, ok := formatters[format]
+	if !ok {
+		return fmt.Errorf("unknown format: %s", format)
+	}
+
+	var out bytes.Buffer
+	if err := formatter(r, &out, r.lastResult); err != nil {
+		return err
+	}
+	if err := writeClipboard(out.String()); err != nil {
+		return err
+	}
+	fmt.Printf("Copied %d rows.\n", len(r.lastResult.Rows))
+	return nil
+}
+
+// pipe runs a query and feeds its formatted result to a shell command, whose
+// output is printed in place of the result. A command with spaces must be
+// quoted. Usage: \pipe <command> <query>
+func (r *repl) pipe(args string) error {
+	command, query, err := splitCommand(args)
+	if err != nil || command == "" || query == "" {
+		return fmt.Errorf("usage: \\pipe <command> <query>")
+	}
+
+	// a statement turned down leaves the last result as it was
+	conn, query := r.connectionFor(query)
+	if !r.allowed(conn, query) {
+		return errNotRun
+	}
+	result := r.executeUnchecked(conn, query)
+	r.lastResult = result
+
+	// the body is piped and the message and error printed as usual
+	var body bytes.Buffer
+	if len(result.Columns) > 0 {
+		if err := formatters[r.format](r, &body, result); err != nil {
+			return err
+		}
+	}
+	r.printQueryResult(&protocol.QueryResult{Message: result.Message, Error: result.Error})
+	if result.Error != "" {
+		return nil
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = &body
+	cmd.Stdout = r.output
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", command, err)
+	}
+	return nil
+}
+
+// splitCommand splits off the first argument of args, which may be wrapped in
+// double quotes, returning it and the rest of args.
+func splitCommand(args string) (command, rest string, err error) {
+	args = strings.TrimSpace(args)
+	if quoted, ok := strings.CutPrefix(args, `"`); ok {
+		end := strings.Index(quoted, `"`)
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quote")
+		}
+		return quoted[:end], strings.TrimSpace(quoted[end+1:]), nil
+	}
+	command, rest, _ = strings.Cut(args, " ")
+	return command, strings.TrimSpace(rest), nil
+}
+
+// cell prints a single value from the last result in full. Rows are numbered
+// from 1; the column may be given by name or by its 1-based position.
+// Usage: \cell <row> <col>
+func (r *repl) cell(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return fmt.Errorf("usage: \\cell <row> <col>")
+	}
+	if r.lastResult == nil {
+		return fmt.Errorf("no result to inspect")
+	}
+
+	row, err := strconv.Atoi(fields[0])
+	if err != nil || row < 1 || row > len(r.lastResult.Rows) {
+		return fmt.Errorf("row must be between 1 and %d", len(r.lastResult.Rows))
+	}
+
+	col, err := columnIndex(r.lastResult.Columns, fields[1])
+	if err != nil {
+		return err
+	}
+
+	values := r.lastResult.Rows[row-1].Values
+	if col >= len(values) {
+		return fmt.Errorf("row %d has no value for column %s", row, fields[1])
+	}
+	fmt.Println(values[col])
+	return nil
+}
+
+// lob writes a single value of the last result to a file as the database
+// returned it, rather than as printed, for binary and large values. The last
+// query is run again to fetch it. Usage: \lob <row> <col> <file>
+func (r *repl) lob(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 3 {
+		return fmt.Errorf("usage: \\lob <row> <col> <file>")
+	}
+	if r.lastResult == nil || r.lastConn == nil {
+		return fmt.Errorf("no result to inspect")
+	}
+
+	row, err := strconv.Atoi(fields[0])
+	if err != nil || row < 1 || row > len(r.lastResult.Rows) {
+		return fmt.Errorf("row must be between 1 and %d", len(r.lastResult.Rows))
+	}
+	col, err := columnIndex(r.lastResult.Columns, fields[1])
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(fields[2])
+	if err != nil {
+		return err
+	}
+	written, err := r.lastConn.CopyValue(file, r.lastConn.LastQuery(), row-1, col)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fields[2])
+		return err
+	}
+	fmt.Printf("Wrote %d bytes to %s.\n", written, fields[2])
+	return nil
+}
+
+// columnIndex resolves a column given by name or 1-based position to its
+// index in columns.
+func columnIndex(columns []string, col string) (int, error) {
+	if n, err := strconv.Atoi(col); err == nil {
+		if n < 1 || n > len(columns) {
+			return 0, fmt.Errorf("column must be between 1 and %d", len(columns))
+		}
+		return n - 1, nil
+	}
+	for i, name := range columns {
+		if name == col {
+			return i, nil
+		}
+	}
+	for i, name := range columns {
+		if strings.EqualFold(name, col) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no such column: %s", col)
+}
+
+// call runs an Oracle procedure that returns its rows through a ref cursor
+// OUT parameter, bound to the call's only placeholder.
+// Usage: \call my_pkg.get_orders(42, :cur)
+func (r *repl) call(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\call <procedure call with a :cursor placeholder>")
+	}
+
+	call := args
+	if !strings.HasPrefix(strings.ToUpper(call), "BEGIN") {
+		call = fmt.Sprintf("BEGIN %s; END;", strings.TrimSuffix(call, ";"))
+	}
+
+	result := r.conn.CallProcedure(call)
+	r.lastResult = result
+	r.printQueryResult(result)
+	return nil
+}
+
+// pset changes how results are printed. Usage: \pset <option> <value>
+func (r *repl) pset(args string) error {
+	option, value, _ := strings.Cut(args, " ")
+	value = strings.TrimSpace(value)
+
+	switch option {
+	case "border":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 2 {
+			return fmt.Errorf("border must be 0, 1, or 2")
+		}
+		r.border = n
+		fmt.Printf("Border style is %d.\n", n)
+	case "trim":
+		switch value {
+		case "on":
+			r.trim = true
+		case "off":
+			r.trim = false
+		default:
+			return fmt.Errorf("trim must be on or off")
+		}
+		fmt.Printf("Whitespace trimming is %s.\n", value)
+	case "displaylimit":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("displaylimit must be a number of rows, or 0 for all of them")
+		}
+		r.displayLimit = n
+		if n == 0 {
+			fmt.Println("Display limit is off.")
+		} else {
+			fmt.Printf("Display limit is %d rows.\n", n)
+		}
+	case "pagesize":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("pagesize must be a number of rows, or 0 to not page")
+		}
+		r.pageSize = n
+		if n == 0 {
+			fmt.Println("Paging is off.")
+		} else {
+			fmt.Printf("Page size is %d rows.\n", n)
+		}
+	case "widths":
+		var widths []int
+		if value != "auto" {
+			for _, field := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(strings.TrimSpace(field))
+				if err != nil || n < 0 {
+					return fmt.Errorf("widths must be a comma-separated list of byte widths, like 10,20,8, or auto")
+				}
+				widths = append(widths, n)
+			}
+		}
+		r.fixedWidths = widths
+		if widths == nil {
+			fmt.Println("Fixed widths fit each column's values.")
+		} else {
+			fmt.Printf("Fixed widths are %s.\n", value)
+		}
+	case "json-typed":
+		switch value {
+		case "on":
+			r.jsonTyped = true
+		case "off":
+			r.jsonTyped = false
+		default:
+			return fmt.Errorf("json-typed must be on or off")
+		}
+		fmt.Printf("Typed JSON values are %s.\n", value)
+	default:
+		return fmt.Errorf("unknown option: %s", option)
+	}
+	return nil
+}
+
+// showSQL prints the last query sent to the database, after variables were
+// substituted and any changes the driver needs were made. Usage: \sql
+func (r *repl) showSQL(args string) error {
+	query := ""
+	if r.lastConn != nil {
+		query = r.lastConn.LastQuery()
+	}
+	if query == "" {
+		return fmt.Errorf("no query has been run yet")
+	}
+	fmt.Println(query)
+	return nil
+}
+
+// setColumnFormat sets the rule used to format a column's values when they're
+// printed, or removes it with "off". With no arguments it lists the rules in
+// use. Usage: \fmt [<column> currency|percent|thousands|bytes|date|off]
+func (r *repl) setColumnFormat(args string) error {
+	if args == "" {
+		columns := make([]string, 0, len(r.columnFormats))
+		for col := range r.columnFormats {
+			columns = append(columns, col)
+		}
+		sort.Strings(columns)
+		for _, col := range columns {
+			fmt.Printf("%s\t%s\n", col, r.columnFormats[col])
+		}
+		return nil
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return fmt.Errorf("usage: \\fmt <column> <format>|off")
+	}
+	col, rule := fields[0], fields[1]
+	if rule == "off" {
+		delete(r.columnFormats, col)
+		return nil
+	}
+	if _, ok := valueFormats[rule]; !ok {
+		return fmt.Errorf("unknown column format: %s", rule)
+	}
+	r.columnFormats[col] = rule
+	return nil
+}
+
+// setFormat changes the format results are printed in, or prints the current
+// one. Usage: \format [table|csv|tsv|json|xml|html|insert|vertical|fixed|yaml]
+func (r *repl) setFormat(args string) error {
+	if args != "" {
+		if _, ok := formatters[args]; !ok {
+			return fmt.Errorf("unknown format: %s", args)
+		}
+		r.format = args
+	}
+	fmt.Printf("Output format is %s.\n", r.format)
+	return nil
+}
+
+// setTuplesOnly turns tuples-only output on or off, or toggles it when no
+// argument is given. Usage: \t [on|off]
+func (r *repl) setTuplesOnly(args string) error {
+	switch args {
+	case "":
+		r.tuplesOnly = !r.tuplesOnly
+	case "on":
+		r.tuplesOnly = true
+	case "off":
+		r.tuplesOnly = false
+	default:
+		return fmt.Errorf("usage: \\t [on|off]")
+	}
+	if r.tuplesOnly {
+		fmt.Println("Tuples only is on.")
+	} else {
+		fmt.Println("Tuples only is off.")
+	}
+	return nil
+}
+
+// reorder prints the named columns first, in the order given, for the last
+// result, which is printed again, and those after it. With no arguments the
+// columns go back to the order the query returns them in.
+// Usage: \reorder [col,col,...]
+func (r *repl) reorder(args string) error {
+	r.columnOrder = nil
+	for _, col := range strings.Split(args, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			r.columnOrder = append(r.columnOrder, col)
+		}
+	}
+
+	if r.lastResult != nil && len(r.lastResult.Columns) > 0 {
+		r.printQueryResult(&protocol.QueryResult{
+			Columns:     r.lastResult.Columns,
+			ColumnTypes: r.lastResult.ColumnTypes,
+			Rows:        r.lastResult.Rows,
+		})
+	}
+	return nil
+}
+
+// grepColumns prints the last result again with only the columns whose names
+// match a regular expression. The result itself is kept whole.
+// Usage: \grep-cols <pattern>
+func (r *repl) grepColumns(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\grep-cols <pattern>")
+	}
+	if r.lastResult == nil || len(r.lastResult.Columns) == 0 {
+		return fmt.Errorf("no result to filter")
+	}
+	pattern, err := regexp.Compile(args)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	matched := matchingColumns(r.lastResult, pattern)
+	if len(matched.Columns) == 0 {
+		return fmt.Errorf("no column matches %s", args)
+	}
+	r.printQueryResult(matched)
+	return nil
+}
+
+// grepRows prints the last result again with only the rows that have a value
+// matching a regular expression. The result itself is kept whole.
+// Usage: \grep-rows <pattern>
+func (r *repl) grepRows(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\grep-rows <pattern>")
+	}
+	if r.lastResult == nil || len(r.lastResult.Columns) == 0 {
+		return fmt.Errorf("no result to filter")
+	}
+	pattern, err := regexp.Compile(args)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	matched := matchingRows(r.lastResult, pattern)
+	r.printQueryResult(matched)
+	fmt.Printf("(%d of %d rows match)\n", len(matched.Rows), len(r.lastResult.Rows))
+	return nil
+}
+
+// reset puts every setting back to how it was when the session started and
+// sends results to stdout again. Variables and the connection are kept.
+// Usage: \reset
+func (r *repl) reset(args string) error {
+	r.settings = defaultSettings()
+	r.closeOutput()
+	fmt.Println("Settings reset.")
+	return nil
+}
+
+// setTimingBreakdown turns on or off printing, after each result, how long the
+// database took to have the first rows ready and how long reading them all
+// took, to tell a slow query from a large result.
+// Usage: \timing-breakdown on|off
+func (r *repl) setTimingBreakdown(args string) error {
+	switch args {
+	case "on":
+		r.timingBreakdown = true
+	case "off":
+		r.timingBreakdown = false
+	default:
+		return fmt.Errorf("usage: \\timing-breakdown on|off")
+	}
+	fmt.Printf("Timing breakdown is %s.\n", args)
+	return nil
+}
+
+// setSafe turns safe mode on or off. Usage: \safe on|off
+func (r *repl) setSafe(args string) error {
+	switch args {
+	case "on":
+		r.safe = true
+	case "off":
+		r.safe = false
+	default:
+		return fmt.Errorf("usage: \\safe on|off")
+	}
+	fmt.Printf("Safe mode is %s.\n", args)
+	return nil
+}
+
+// checksum runs a query and prints a hash of its result that doesn't depend on
+// the order of the rows, for checking that two databases hold the same data.
+// Usage: \checksum <query>
+func (r *repl) checksum(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\checksum <query>")
+	}
+
+	conn, query := r.connectionFor(args)
+	if !r.allowed(conn, query) {
+		return errNotRun
+	}
+	result := r.executeUnchecked(conn, query)
+	if result.Error != "" {
+		return fmt.Errorf("%s", result.Error)
+	}
+	r.lastResult = result
+
+	fmt.Printf("%s (%d rows)\n", resultChecksum(result), len(result.Rows))
+	return nil
+}
+
+// resultChecksum returns the hex SHA-256 of the column names and the sorted
+// rows of result.
+func resultChecksum(result *protocol.QueryResult) string {
+	rows := canonicalRows(result)
+	sort.Strings(rows)
+
+	hash := sha256.New()
+	columns, _ := json.Marshal(result.Columns)
+	hash.Write(columns)
+	for _, row := range rows {
+		hash.Write([]byte("\n"))
+		hash.Write([]byte(row))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// canonicalRows returns each row of result encoded as a JSON array, so that no
+// value can be mistaken for a separator when rows are hashed or compared.
+func canonicalRows(result *protocol.QueryResult) []string {
+	rows := make([]string, len(result.Rows))
+	for n, row := range result.Rows {
+		values := make([]string, len(result.Columns))
+		for i := range values {
+			values[i] = rowValue(row, i)
+		}
+		encoded, _ := json.Marshal(values)
+		rows[n] = string(encoded)
+	}
+	return rows
+}
+
+// compare runs a query on two of the connections opened with \open and
+// reports whether they return the same rows, ignoring their order. If not, the
+// rows only one of them returned are printed.
+// Usage: \compare <name> <name> <query>
+func (r *repl) compare(args string) error {
+	fields := strings.SplitN(args, " ", 3)
+	if len(fields) < 3 || strings.TrimSpace(fields[2]) == "" {
+		return fmt.Errorf("usage: \\compare <name> <name> <query>")
+	}
+	names, query := fields[:2], substitute(strings.TrimSpace(fields[2]), r.vars)
+
+	results := make([]*protocol.QueryResult, 2)
+	for i, name := range names {
+		conn, ok := r.conns[name]
+		if !ok {
+			return fmt.Errorf("no connection named %s, see \\open", name)
+		}
+		if !r.allowed(conn, query) {
+			return errNotRun
+		}
+		results[i] = conn.ExecuteQuery(query)
+		if results[i].Error != "" {
+			return fmt.Errorf("%s: %s", name, results[i].Error)
+		}
+	}
+
+	if !slices.Equal(results[0].Columns, results[1].Columns) {
+		fmt.Printf("Columns differ: %s has %s, %s has %s\n",
+			names[0], strings.Join(results[0].Columns, ", "), names[1], strings.Join(results[1].Columns, ", "))
+		return nil
+	}
+	if resultChecksum(results[0]) == resultChecksum(results[1]) {
+		fmt.Printf("Results match (%d rows).\n", len(results[0].Rows))
+		return nil
+	}
+
+	// a row returned more often by one side than the other counts as
+	// missing from the other side as many times
+	counts := map[string]int{}
+	for _, row := range canonicalRows(results[0]) {
+		counts[row]++
+	}
+	for _, row := range canonicalRows(results[1]) {
+		counts[row]--
+	}
+
+	diff := &protocol.QueryResult{Columns: append([]string{"only in"}, results[0].Columns...)}
+	for i, result := range results {
+		sign := 1
+		if i == 1 {
+			sign = -1
+		}
+		for n, row := range canonicalRows(result) {
+			if counts[row]*sign > 0 {
+				counts[row] -= sign
+				diff.Rows = append(diff.Rows, &protocol.Row{Values: append([]string{names[i]}, result.Rows[n].Values...)})
+			}
+		}
+	}
+
+	fmt.Printf("Results differ: %s has %d rows, %s has %d rows, %d rows differ.\n",
+		names[0], len(results[0].Rows), names[1], len(results[1].Rows), len(diff.Rows))
+	r.lastResult = diff
+	r.printQueryResult(diff)
+	return nil
+}
+
+// stats runs a query and summarizes each of its columns: min/max/avg for
+// numeric columns, distinct values and longest value for text columns.
+// Usage: \stats <query>
+func (r *repl) stats(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\stats <query>")
+	}
+
+	conn, query := r.connectionFor(args)
+	if !r.allowed(conn, query) {
+		return errNotRun
+	}
+	result := r.executeUnchecked(conn, query)
+	if result.Error != "" {
+		return fmt.Errorf("%s", result.Error)
+	}
+	r.lastResult = result
+
+	summary := &protocol.QueryResult{
+		Columns: []string{"column", "kind", "rows", "nulls", "min", "max", "avg", "distinct", "max_length"},
+	}
+	for i, col := range result.Columns {
+		summary.Rows = append(summary.Rows, &protocol.Row{Values: columnStats(col, i, result.Rows)})
+	}
+
+	r.printQueryResult(summary)
+	return nil
+}
+
+// columnStats summarizes column i of rows. A column is treated as numeric if
+// every non-null value in it parses as a number.
+func columnStats(name string, i int, rows []*protocol.Row) []string {
+	var values []string
+	nulls := 0
+	for _, row := range rows {
+		if i >= len(row.Values) || row.Values[i] == database.FormatValue(nil) {
+			nulls++
+			continue
+		}
+		values = append(values, row.Values[i])
+	}
+
+	numbers := make([]float64, 0, len(values))
+	for _, value := range values {
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			break
+		}
+		numbers = append(numbers, n)
+	}
+
+	stats := []string{name, "", strconv.Itoa(len(rows)), strconv.Itoa(nulls), "", "", "", "", ""}
+	if len(values) > 0 && len(numbers) == len(values) {
+		minimum, maximum, total := numbers[0], numbers[0], 0.0
+		for _, n := range numbers {
+			minimum = min(minimum, n)
+			maximum = max(maximum, n)
+			total += n
+		}
+		stats[1] = "numeric"
+		stats[4] = strconv.FormatFloat(minimum, 'g', -1, 64)
+		stats[5] = strconv.FormatFloat(maximum, 'g', -1, 64)
+		stats[6] = strconv.FormatFloat(total/float64(len(numbers)), 'g', -1, 64)
+		return stats
+	}
+
+	distinct := map[string]bool{}
+	longest := 0
+	for _, value := range values {
+		distinct[value] = true
+		longest = max(longest, utf8.RuneCountInString(value))
+	}
+	stats[1] = "text"
+	stats[7] = strconv.Itoa(len(distinct))
+	stats[8] = strconv.Itoa(longest)
+	return stats
+}
+
+// setOutput sends query results to a file, or back to stdout when no file is
+// given. Usage: \o [file]
+func (r *repl) setOutput(args string) error {
+	var file *os.File
+	if args != "" {
+		var err error
+		file, err = os.Create(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.closeOutput()
+	if file != nil {
+		r.output = file
+		r.outputFile = file
+	}
+	return nil
+}
+
+// closeOutput closes the file results are being written to, if any, and goes
+// back to writing them to stdout.
+func (r *repl) closeOutput() {
+	if r.outputFile != nil {
+		r.outputFile.Close()
+		r.outputFile = nil
+	}
+	r.output = os.Stdout
+}
+
+// export writes the last result to a file in the given format. For the insert
+// format the target table may be named, overriding -insert-table.
+// Usage: \export <format> <file> [table]
+func (r *repl) export(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) < 2 || len(fields) > 3 {
+		return fmt.Errorf("usage: \\export <format> <file> [table]")
+	}
+	if r.lastResult == nil {
+		return fmt.Errorf("no result to export")
+	}
+	formatter, ok := formatters[fields[0]]
+	if !ok {
+		return fmt.Errorf("unknown format: %s", fields[0])
+	}
+
+	file, err := os.Create(fields[1])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if len(fields) == 3 {
+		defer func(table string) { r.insertTable = table }(r.insertTable)
+		r.insertTable = fields[2]
+	}
+	if err = formatter(r, file, r.lastResult); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d rows to %s\n", len(r.lastResult.Rows), fields[1])
+	return nil
+}
+
+// prettyJSON pretty-prints a column of the last result as JSON, one row at a
+// time, flagging values that aren't valid JSON. Usage: \json <column>
+func (r *repl) prettyJSON(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\json <column>")
+	}
+	if r.lastResult == nil {
+		return fmt.Errorf("no result to inspect")
+	}
+	col, err := columnIndex(r.lastResult.Columns, args)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range r.lastResult.Rows {
+		fmt.Printf("-- row %d\n", i+1)
+		if col >= len(row.Values) || row.Values[col] == database.FormatValue(nil) {
+			fmt.Println("null")
+			continue
+		}
+
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, []byte(row.Values[col]), "", "  "); err != nil {
+			fmt.Printf("invalid JSON (%v): %s\n", err, row.Values[col])
+			continue
+		}
+		fmt.Println(pretty.String())
+	}
+	return nil
+}
+
+// materialize runs a query and copies its result into a table in the local
+// in-memory store, where it can be queried with the local> prefix.
+// Usage: \materialize <query> AS <name>
+func (r *repl) materialize(args string) error {
+	i := strings.LastIndex(strings.ToUpper(args), " AS ")
+	if i < 0 {
+		return fmt.Errorf("usage: \\materialize <query> AS <name>")
+	}
+	query, name := strings.TrimSpace(args[:i]), strings.TrimSpace(args[i+len(" AS "):])
+	if query == "" || name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("usage: \\materialize <query> AS <name>")
+	}
+	if !r.allowed(r.conn, query) {
+		return errNotRun
+	}
+
+	result, err := r.conn.ExecuteQueryTyped(query)
+	if err != nil {
+		return err
+	}
+
+	if r.local == nil {
+		if r.local, err = database.OpenLocalStore(r.conn.Options.QueryTimeout); err != nil {
+			return err
+		}
+	}
+	if err = r.local.Load(name, result); err != nil {
+		return fmt.Errorf("failed to materialize %s: %w", name, err)
+	}
+	fmt.Printf("Materialized %d rows as %s; query it with %s\n", len(result.Rows), name, localPrefix)
+	return nil
+}
+
+// into runs a query and inserts its result into a table on a connection
+// opened with \open, creating the table to fit the result if it doesn't
+// exist. Usage: \into <name>.<table> <query>
+func (r *repl) into(args string) error {
+	target, query, _ := strings.Cut(args, " ")
+	name, table, _ := strings.Cut(target, ".")
+	query = strings.TrimSpace(query)
+	if name == "" || table == "" || query == "" {
+		return fmt.Errorf("usage: \\into <name>.<table> <query>")
+	}
+	dst, ok := r.conns[name]
+	if !ok {
+		return fmt.Errorf("no connection named %s, see \\open", name)
+	}
+	if !r.allowed(r.conn, query) {
+		return errNotRun
+	}
+
+	result, err := r.conn.ExecuteQueryTyped(query)
+	if err != nil {
+		return err
+	}
+
+	created, err := dst.InsertResult(table, result)
+	if err != nil {
+		return fmt.Errorf("failed to insert into %s on %s: %w", table, name, err)
+	}
+	if created {
+		fmt.Printf("Created %s on %s.\n", table, name)
+	}
+	fmt.Printf("Inserted %d rows into %s on %s.\n", len(result.Rows), table, name)
+	return nil
+}
+
+// truncate removes every row from a table, after showing how many there are
+// and asking to go ahead. Usage: \truncate <table>
+func (r *repl) truncate(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\truncate <table>")
+	}
+	dbType := r.dbType()
+
+	result := r.execute("SELECT COUNT(*) FROM " + database.QuoteName(dbType, args))
+	if result.Error != "" {
+		return fmt.Errorf("%s", result.Error)
+	}
+	if len(result.Rows) != 1 || len(result.Rows[0].Values) != 1 {
+		return fmt.Errorf("failed to count the rows of %s", args)
+	}
+	fmt.Printf("%s has %s rows.\n", args, result.Rows[0].Values[0])
+	if !r.confirm("Type YES to remove them all: ") {
+		fmt.Println("Not truncated.")
+		return nil
+	}
+
+	// the user has just confirmed it, so it isn't asked about again
+	statement := database.TruncateStatement(dbType, args)
+	result = r.executeUnchecked(r.connectionFor(statement))
+	if result.Error != "" {
+		return fmt.Errorf("%s", result.Error)
+	}
+	fmt.Printf("Truncated %s.\n", args)
+	return nil
+}
+
+// begin starts a transaction. Usage: \begin
+func (r *repl) begin(args string) error {
+	return r.conn.Begin()
+}
+
+// commit commits the open transaction. Usage: \commit
+func (r *repl) commit(args string) error {
+	if err := r.conn.Commit(); err != nil {
+		return err
+	}
+	fmt.Println("Committed.")
+	return nil
+}
+
+// rollback rolls back the open transaction. Usage: \rollback
+func (r *repl) rollback(args string) error {
+	if err := r.conn.Rollback(); err != nil {
+		return err
+	}
+	fmt.Println("Rolled back.")
+	return nil
+}
+
+// set changes a session setting or sets a variable to substitute for :name in
+// queries. With no arguments it lists the variables.
+// Usage: \set [AUTOCOMMIT on|off | PROMPT template | name value]
+func (r *repl) set(args string) error {
+	name, value, _ := strings.Cut(args, " ")
+	value = strings.TrimSpace(value)
+
+	switch strings.ToUpper(name) {
+	case "":
+		names := make([]string, 0, len(r.vars))
+		for name := range r.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s = %s\n", name, r.vars[name])
+		}
+	case "AUTOCOMMIT":
+		if value != "on" && value != "off" {
+			return fmt.Errorf("AUTOCOMMIT must be on or off")
+		}
+		if r.conn == nil {
+			return fmt.Errorf("AUTOCOMMIT needs a direct database connection, not a server")
+		}
+		if err := r.conn.SetAutocommit(value == "on"); err != nil {
+			return err
+		}
+		fmt.Printf("Autocommit is %s.\n", value)
+	case "PROMPT":
+		// quotes keep the spaces at the ends of the prompt
+		if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		r.promptFormat = value
+	default:
+		for i := range name {
+			if !isVariableChar(name[i], i == 0) {
+				return fmt.Errorf("invalid variable name: %s", name)
+			}
+		}
+		r.vars[name] = value
+	}
+	return nil
+}
+
+// endTransaction asks whether to commit or roll back each transaction that's
+// still open as the session ends. Anything but a commit rolls it back.
+func (r *repl) endTransaction() {
+	names := make([]string, 0, len(r.conns))
+	for name := range r.conns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		conn := r.conns[name]
+		if !conn.InTransaction() {
+			continue
+		}
+
+		if len(r.conns) > 1 {
+			fmt.Printf("A transaction is still open on %s. Commit it? [y/N] ", name)
+		} else {
+			fmt.Print("A transaction is still open. Commit it? [y/N] ")
+		}
+		if r.input.Scan() && strings.EqualFold(strings.TrimSpace(r.input.Text()), "y") {
+			if err := conn.Commit(); err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			fmt.Println("Committed.")
+			continue
+		}
+
+		if err := conn.Rollback(); err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		fmt.Println("Rolled back.")
+	}
+}
+
+// open connects to another database, which queries can be sent to by starting
+// them with its name, e.g. "dst> SELECT 1", or which \switch makes the
+// default. With no arguments it lists the open connections.
+// Usage: \open [<name> <dbtype> <connstring>]
+func (r *repl) open(args string) error {
+	if args == "" {
+		list := &protocol.QueryResult{Columns: []string{"name", "dbtype", "current"}}
+		for name, conn := range r.conns {
+			current := ""
+			if name == r.connName {
+				current = "*"
+			}
+			list.Rows = append(list.Rows, &protocol.Row{Values: []string{name, database.DBTypeString(conn.DBType()), current}})
+		}
+		sort.Slice(list.Rows, func(i, j int) bool { return list.Rows[i].Values[0] < list.Rows[j].Values[0] })
+		r.printQueryResult(list)
+		return nil
+	}
+
+	name, rest, _ := strings.Cut(args, " ")
+	dbType, connString, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	connString = strings.TrimSpace(connString)
+	if connString == "" {
+		return fmt.Errorf("usage: \\open <name> <dbtype> <connstring>")
+	}
+	if name == "local" || strings.ContainsAny(name, "> ") {
+		return fmt.Errorf("%s can't be used as a connection name", name)
+	}
+	if _, ok := r.conns[name]; ok {
+		return fmt.Errorf("a connection named %s is already open", name)
+	}
+
+	connString, err := resolveConnString(connString)
+	if err != nil {
+		return err
+	}
+	conn := &database.Connection{Options: connectionOptions()}
+	if err = conn.Connect(dbType, connString); err != nil {
+		return err
+	}
+	r.logQueries(conn)
+	r.conns[name] = conn
+	fmt.Printf("Opened %s; query it with %s>, or \\switch %s\n", name, name, name)
+	return nil
+}
+
+// switchConnection makes a connection opened with \open the one queries run
+// on. Usage: \switch <name>
+func (r *repl) switchConnection(args string) error {
+	conn, ok := r.conns[args]
+	if !ok {
+		return fmt.Errorf("no connection named %s, see \\open", args)
+	}
+	r.conn = conn
+	r.connName = args
+	r.schema, _ = conn.CurrentSchema()
+	fmt.Printf("Queries now run on %s.\n", args)
+	return nil
+}
+
+// use changes the current schema (the database, for mysql). For postgres a
+// comma-separated search path may be given. Usage: \use <schema>
+func (r *repl) use(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\use <schema>")
+	}
+	if err := r.conn.SetSchema(args); err != nil {
+		return err
+	}
+
+	schema, err := r.conn.CurrentSchema()
+	if err != nil || schema == "" {
+		schema = args
+	}
+	r.schema = schema
+	fmt.Printf("Using schema %s.\n", schema)
+	return nil
+}
+
+// cols shows the columns a query returns, and their types, without fetching
+// any rows. Usage: \cols <query>
+func (r *repl) cols(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\cols <query>")
+	}
+	r.printQueryResult(r.conn.QueryColumns(args))
+	return nil
+}
+
+// indexes shows the indexes on a table, with their columns, whether they're
+// unique, and their type. Usage: \indexes <table>
+func (r *repl) indexes(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\indexes <table>")
+	}
+	r.printQueryResult(r.conn.Indexes(args))
+	return nil
+}
+
+// dumpSchema prints the CREATE statements for every table, or just the one
+// named. Usage: \dump-schema [table]
+func (r *repl) dumpSchema(args string) error {
+	statements, err := r.conn.DumpSchema(args)
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		fmt.Fprintf(r.output, "%s;\n\n", strings.TrimRight(strings.TrimSpace(statement), ";"))
+	}
+	return nil
+}
+
+// repeatedFailure records the outcome of a query and reports whether the last
+// -max-errors queries have all failed with the same error, which usually
+// means the connection has gone bad.
+func (r *repl) repeatedFailure(result *protocol.QueryResult) bool {
+	if result.Error == "" || result.Error != r.lastError {
+		r.errorStreak = 0
+	}
+	r.lastError = result.Error
+	if result.Error != "" {
+		r.errorStreak++
+	}
+	return *maxErrors > 0 && r.errorStreak >= *maxErrors
+}
+
+// recoverSession asks what to do after repeated failures: reconnect, exit, or carry
+// on. It reports whether the session should continue.
+func (r *repl) recoverSession() bool {
+	fmt.Printf("The last %d queries failed with the same error. [r]econnect, [e]xit, or [c]ontinue? ", r.errorStreak)
+	if !r.input.Scan() {
+		return false
+	}
+	r.errorStreak = 0
+
+	switch strings.ToLower(strings.TrimSpace(r.input.Text())) {
+	case "r", "reconnect":
+		if err := r.reconnect(); err != nil {
+			fmt.Println("Error:", err)
+			return true
+		}
+		fmt.Println("Reconnected.")
+	case "e", "exit":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+
+	"sqlrepl/internal/database"
+)
+
+func init() {
+	// go-sqlite3 registers itself as sqlite3, but connections open the
+	// driver named after the database type
+	sql.Register("sqlite", &sqlite3.SQLiteDriver{})
+}
+
+// openRepl starts a session on a new sqlite database for a test, running
+// setup statements on it first. input is what the user types in reply to
+// any question the session asks.
+func openRepl(t *testing.T, input string, setup ...string) *repl {
+	t.Helper()
+	conn := &database.Connection{}
+	if err := conn.Connect("sqlite", filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	for _, statement := range setup {
+		if result := conn.ExecuteQuery(statement); result.Error != "" {
+			t.Fatalf("%s: %s", statement, result.Error)
+		}
+	}
+	return newRepl(conn, bufio.NewScanner(strings.NewReader(input)))
+}
+
+// countRows returns how many rows table has.
+func countRows(t *testing.T, r *repl, table string) string {
+	t.Helper()
+	result := r.conn.ExecuteQuery("SELECT COUNT(*) FROM " + table)
+	if result.Error != "" || len(result.Rows) != 1 {
+		t.Fatalf("counting %s: %s", table, result.Error)
+	}
+	return result.Rows[0].Values[0]
+}
+
+// guardSetup are the statements the safe mode tests run on a new database.
+var guardSetup = []string{
+	"CREATE TABLE t (id INTEGER)",
+	"INSERT INTO t VALUES (1)",
+	"INSERT INTO t VALUES (2)",
+}
+
+func TestSafeModeGuardsProfile(t *testing.T) {
+	r := openRepl(t, "no\n", guardSetup...)
+	r.safe = true
+
+	err := r.runCommand(`\profile 1 DELETE FROM t`)
+	if !errors.Is(err, errNotRun) {
+		t.Errorf("got error %v, want %v", err, errNotRun)
+	}
+	if rows := countRows(t, r, "t"); rows != "2" {
+		t.Errorf("t has %s rows after the DELETE was turned down, want 2", rows)
+	}
+}
+
+func TestSafeModeRunsConfirmed(t *testing.T) {
+	r := openRepl(t, "YES\n", guardSetup...)
+	r.safe = true
+
+	if err := r.runCommand(`\profile 1 DELETE FROM t`); err != nil {
+		t.Fatal(err)
+	}
+	if rows := countRows(t, r, "t"); rows != "0" {
+		t.Errorf("t has %s rows after the DELETE was confirmed, want 0", rows)
+	}
+}
+
+func TestSafeModeGuardsPipe(t *testing.T) {
+	r := openRepl(t, "no\n", guardSetup...)
+	r.safe = true
+
+	err := r.runCommand(`\pipe cat DELETE FROM t`)
+	if !errors.Is(err, errNotRun) {
+		t.Errorf("got error %v, want %v", err, errNotRun)
+	}
+	if rows := countRows(t, r, "t"); rows != "2" {
+		t.Errorf("t has %s rows after the DELETE was turned down, want 2", rows)
+	}
+	if r.lastResult != nil {
+		t.Errorf("the last result was replaced by a statement that wasn't run")
+	}
+}
+
+func TestSafeModeGuardsChecksum(t *testing.T) {
+	r := openRepl(t, "no\n", guardSetup...)
+	r.safe = true
+
+	err := r.runCommand(`\checksum DELETE FROM t RETURNING id`)
+	if !errors.Is(err, errNotRun) {
+		t.Errorf("got error %v, want %v", err, errNotRun)
+	}
+	if rows := countRows(t, r, "t"); rows != "2" {
+		t.Errorf("t has %s rows after the DELETE was turned down, want 2", rows)
+	}
+}
+
+func TestSafeModeGuardsStats(t *testing.T) {
+	r := openRepl(t, "no\n", guardSetup...)
+	r.safe = true
+
+	err := r.runCommand(`\stats DELETE FROM t RETURNING id`)
+	if !errors.Is(err, errNotRun) {
+		t.Errorf("got error %v, want %v", err, errNotRun)
+	}
+	if rows := countRows(t, r, "t"); rows != "2" {
+		t.Errorf("t has %s rows after the DELETE was turned down, want 2", rows)
+	}
+}
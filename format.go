@@ -0,0 +1,940 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+	"gopkg.in/yaml.v3"
+
+	"sqlrepl/internal/database"
+	"sqlrepl/internal/protocol"
+)
+
+// formatter writes a result to w in one of the output formats.
+type formatter func(r *repl, w io.Writer, result *protocol.QueryResult) error
+
+var formatters = map[string]formatter{
+	"table": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		writeTable(w, result, r.border)
+		return nil
+	},
+	"insert": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		writeInserts(w, result, r.insertTable, r.dbType())
+		return nil
+	},
+	"csv": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		return writeCSV(w, result, ',')
+	},
+	"tsv": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		return writeCSV(w, result, '\t')
+	},
+	"json": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		return writeJSON(w, result, r.jsonTyped)
+	},
+	"xml": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		return writeXML(w, result)
+	},
+	"html": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		return writeHTML(w, result)
+	},
+	"vertical": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		writeVertical(w, result)
+		return nil
+	},
+	"yaml": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		return writeYAML(w, result)
+	},
+	"fixed": func(r *repl, w io.Writer, result *protocol.QueryResult) error {
+		writeFixed(w, result, r.fixedWidths)
+		return nil
+	},
+}
+
+// Flush policies for -flush
+const (
+	flushRow    = "row"
+	flushResult = "result"
+)
+
+// flushWriter buffers a result as it's written. With the row policy it's
+// flushed at the end of every line, so that a slow result shows up as it's
+// written; with the result policy only when Flush is called.
+type flushWriter struct {
+	*bufio.Writer
+	perLine bool
+}
+
+func newFlushWriter(w io.Writer, policy string) *flushWriter {
+	return &flushWriter{Writer: bufio.NewWriter(w), perLine: policy == flushRow}
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err == nil && w.perLine && bytes.IndexByte(p, '\n') >= 0 {
+		err = w.Writer.Flush()
+	}
+	return n, err
+}
+
+// colorWarnings is whether warnings are shown in yellow, which they are when
+// they're printed to a terminal.
+var colorWarnings = func() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}()
+
+// highlightWarning colors a warning so that it stands out from the result
+// and from errors.
+func highlightWarning(warning string) string {
+	if !colorWarnings {
+		return warning
+	}
+	return "\x1b[33m" + warning + ansiReset
+}
+
+// ansiReset ends the color or other style started by an ANSI escape code.
+const ansiReset = "\x1b[0m"
+
+// uniqueColumns returns the column names with duplicates disambiguated by a
+// numeric suffix (id, id_2, id_3), for formats that key values by column name
+// and would otherwise lose all but one of them.
+func uniqueColumns(columns []string) []string {
+	taken := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		taken[col] = true
+	}
+
+	unique := make([]string, len(columns))
+	seen := make(map[string]int, len(columns))
+	for i, col := range columns {
+		seen[col]++
+		if seen[col] == 1 {
+			unique[i] = col
+			continue
+		}
+		name := col
+		for n := seen[col]; taken[name]; n++ {
+			name = fmt.Sprintf("%s_%d", col, n)
+		}
+		taken[name] = true
+		unique[i] = name
+	}
+	return unique
+}
+
+// isNull reports whether value is how a NULL was stringified.
+func isNull(value string) bool {
+	return value == database.FormatValue(nil)
+}
+
+// rowValue returns value i of row, or a NULL if the row is short.
+func rowValue(row *protocol.Row, i int) string {
+	if i < len(row.Values) {
+		return row.Values[i]
+	}
+	return database.FormatValue(nil)
+}
+
+// writeTable writes result as an aligned table. The border style controls how
+// much of the grid is drawn:
+//
+//	0: no lines, columns separated by spaces
+//	1: a line under the header
+//	2: a full grid around every cell, like the mysql client
+func writeTable(w io.Writer, result *protocol.QueryResult, border int) {
+	writeStyledTable(w, result, border, nil)
+}
+
+// writeStyledTable is writeTable with the value in each row and column, both
+// counted from 0, wrapped in the ANSI escape code style returns for it, if
+// any. A nil style leaves every value plain.
+func writeStyledTable(w io.Writer, result *protocol.QueryResult, border int, style func(row, col int) string) {
+	widths := make([]int, len(result.Columns))
+	for i, col := range result.Columns {
+		widths[i] = utf8.RuneCountInString(col)
+	}
+	for _, row := range result.Rows {
+		for i := range widths {
+			if i < len(row.Values) {
+				widths[i] = max(widths[i], utf8.RuneCountInString(row.Values[i]))
+			}
+		}
+	}
+
+	rule := func(cross string) {
+		segments := make([]string, len(widths))
+		for i, width := range widths {
+			segments[i] = strings.Repeat("-", width+2)
+		}
+		fmt.Fprintf(w, "%s%s%s\n", cross, strings.Join(segments, cross), cross)
+	}
+
+	line := func(row int, values []string) {
+		cells := make([]string, len(widths))
+		for i, width := range widths {
+			var value string
+			if i < len(values) {
+				value = values[i]
+			}
+			padding := strings.Repeat(" ", width-utf8.RuneCountInString(value))
+			if row >= 0 && style != nil {
+				if code := style(row, i); code != "" {
+					value = code + value + ansiReset
+				}
+			}
+			cells[i] = value + padding
+		}
+
+		switch border {
+		case 2:
+			fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+		default:
+			fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, "  "), " "))
+		}
+	}
+
+	if border == 2 {
+		rule("+")
+	}
+	line(-1, result.Columns)
+	switch border {
+	case 1:
+		segments := make([]string, len(widths))
+		for i, width := range widths {
+			segments[i] = strings.Repeat("-", width)
+		}
+		fmt.Fprintln(w, strings.Join(segments, "  "))
+	case 2:
+		rule("+")
+	}
+
+	for n, row := range result.Rows {
+		line(n, row.Values)
+	}
+
+	if border == 2 {
+		rule("+")
+	}
+}
+
+// writeFixed writes result as fixed-width text for the programs that read
+// it: every value of a column takes the same number of bytes, padded with
+// spaces or cut short to fit, with no header and nothing between the columns.
+// Numbers are aligned right and NULLs left blank. widths gives the width of
+// each column in turn; the rest are as wide as their longest value.
+func writeFixed(w io.Writer, result *protocol.QueryResult, widths []int) {
+	columnWidths := make([]int, len(result.Columns))
+	for i := range columnWidths {
+		if i < len(widths) {
+			columnWidths[i] = widths[i]
+			continue
+		}
+		for _, row := range result.Rows {
+			if value := rowValue(row, i); !isNull(value) {
+				columnWidths[i] = max(columnWidths[i], len(value))
+			}
+		}
+	}
+
+	for _, row := range result.Rows {
+		var line strings.Builder
+		for i, width := range columnWidths {
+			value := rowValue(row, i)
+			if isNull(value) {
+				value = ""
+			}
+			if len(value) > width {
+				// cut between characters, not through one
+				for width > 0 && !utf8.RuneStart(value[width]) {
+					width--
+				}
+				value = value[:width]
+			}
+			padding := strings.Repeat(" ", columnWidths[i]-len(value))
+			if i < len(result.ColumnTypes) && (result.ColumnTypes[i] == "integer" || result.ColumnTypes[i] == "number") {
+				line.WriteString(padding + value)
+			} else {
+				line.WriteString(value + padding)
+			}
+		}
+		fmt.Fprintln(w, line.String())
+	}
+}
+
+// writeVertical writes each row of result as a record of column/value lines,
+// which is easier to read than a table when rows are wide:
+//
+//	-[ RECORD 1 ]-
+//	id   | 1
+//	name | alice
+func writeVertical(w io.Writer, result *protocol.QueryResult) {
+	width := 0
+	for _, col := range result.Columns {
+		width = max(width, utf8.RuneCountInString(col))
+	}
+
+	for n, row := range result.Rows {
+		fmt.Fprintf(w, "-[ RECORD %d ]-\n", n+1)
+		for i, col := range result.Columns {
+			padding := strings.Repeat(" ", width-utf8.RuneCountInString(col))
+			fmt.Fprintf(w, "%s%s | %s\n", col, padding, rowValue(row, i))
+		}
+	}
+}
+
+// writeValues writes the values of the first column of result one per line,
+// with no header, so that a count or a list of ids can be used in a script.
+// NULLs are written as empty lines.
+func writeValues(w io.Writer, result *protocol.QueryResult) {
+	for _, row := range result.Rows {
+		value := rowValue(row, 0)
+		if isNull(value) {
+			value = ""
+		}
+		fmt.Fprintln(w, value)
+	}
+}
+
+// writeErrorPosition writes the line of query holding the 1-based character
+// position an error was reported at, with a caret under that character:
+//
+//	LINE 1: select * form orders
+//	                 ^
+func writeErrorPosition(w io.Writer, query string, position int) {
+	runes := []rune(query)
+	if position > len(runes)+1 {
+		return
+	}
+
+	start := position - 1
+	for start > 0 && runes[start-1] != '\n' {
+		start--
+	}
+	end := position - 1
+	for end < len(runes) && runes[end] != '\n' {
+		end++
+	}
+	line := strings.Count(string(runes[:start]), "\n") + 1
+
+	prefix := fmt.Sprintf("LINE %d: ", line)
+	// keep tabs so that the caret lines up however they're displayed
+	padding := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, string(runes[start:position-1]))
+
+	fmt.Fprintf(w, "%s%s\n", prefix, strings.TrimRight(string(runes[start:end]), "\r"))
+	fmt.Fprintf(w, "%s%s^\n", strings.Repeat(" ", len(prefix)), padding)
+}
+
+// newlineMarker stands in for line breaks inside values when trimming.
+const newlineMarker = "↵"
+
+// trimValues returns a copy of result for display, with trailing whitespace
+// trimmed from each value and line breaks inside values replaced by a marker
+// so that they don't break up the table.
+func trimValues(result *protocol.QueryResult) *protocol.QueryResult {
+	trimmed := &protocol.QueryResult{
+		Columns:     result.Columns,
+		ColumnTypes: result.ColumnTypes,
+		Message:     result.Message,
+		Error:       result.Error,
+	}
+	for _, row := range result.Rows {
+		values := make([]string, len(row.Values))
+		for i, value := range row.Values {
+			value = strings.TrimRightFunc(value, unicode.IsSpace)
+			value = strings.ReplaceAll(value, "\r\n", newlineMarker)
+			values[i] = strings.ReplaceAll(value, "\n", newlineMarker)
+		}
+		trimmed.Rows = append(trimmed.Rows, &protocol.Row{Values: values})
+	}
+	return trimmed
+}
+
+// writeInserts writes each row of result as an INSERT statement into table,
+// quoting column names as identifiers and values as literals for the given
+// database type.
+func writeInserts(w io.Writer, result *protocol.QueryResult, table string, dbType int) {
+	columns := uniqueColumns(result.Columns)
+	for i, col := range columns {
+		columns[i] = database.QuoteIdentifier(dbType, col)
+	}
+	names := strings.Join(columns, ", ")
+	for _, row := range result.Rows {
+		literals := make([]string, len(result.Columns))
+		for i := range literals {
+			literals[i] = "NULL"
+			if value := rowValue(row, i); !isNull(value) {
+				literals[i] = database.QuoteLiteral(dbType, value)
+			}
+		}
+		fmt.Fprintf(w, "INSERT INTO %s (%s) VALUES (%s);\n", table, names, strings.Join(literals, ", "))
+	}
+}
+
+// writeCSV writes result as CSV with a header row, with fields separated by
+// comma. NULLs are written as empty fields.
+func writeCSV(w io.Writer, result *protocol.QueryResult, comma rune) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	if err := writer.Write(uniqueColumns(result.Columns)); err != nil {
+		return err
+	}
+
+	for _, row := range result.Rows {
+		record := make([]string, len(result.Columns))
+		for i := range record {
+			if value := rowValue(row, i); !isNull(value) {
+				record[i] = value
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeJSON writes result as a JSON array with one object per row, keyed by
+// column name in column order. NULLs are written as null. With typed, values
+// in numeric and boolean columns are written as JSON numbers and booleans
+// rather than strings, see jsonValue.
+func writeJSON(w io.Writer, result *protocol.QueryResult, typed bool) error {
+	columns := uniqueColumns(result.Columns)
+	keys := make([][]byte, len(columns))
+	for i, col := range columns {
+		keys[i], _ = json.Marshal(col)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("[")
+	for n, row := range result.Rows {
+		if n > 0 {
+			out.WriteString(",")
+		}
+		out.WriteString("\n  {")
+		for i := range columns {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			out.Write(keys[i])
+			out.WriteString(": ")
+
+			value := rowValue(row, i)
+			if isNull(value) {
+				out.WriteString("null")
+				continue
+			}
+			if typed && i < len(result.ColumnTypes) {
+				if literal, ok := jsonValue(value, result.ColumnTypes[i]); ok {
+					out.WriteString(literal)
+					continue
+				}
+			}
+			encoded, _ := json.Marshal(value)
+			out.Write(encoded)
+		}
+		out.WriteString("}")
+	}
+	if len(result.Rows) > 0 {
+		out.WriteString("\n")
+	}
+	out.WriteString("]\n")
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// jsonValue returns value as a JSON number or boolean for a column of the
+// given kind. Values that don't parse as one, like a number the database wrote
+// as ".5" or one a \fmt rule has formatted, are left to be written as strings.
+func jsonValue(value, kind string) (string, bool) {
+	switch kind {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err == nil && json.Valid([]byte(value)) {
+			return value, true
+		}
+	case "boolean":
+		switch value {
+		case "true", "t", "1":
+			return "true", true
+		case "false", "f", "0":
+			return "false", true
+		}
+	}
+	return "", false
+}
+
+// writeYAML writes result as a YAML list with a map per row, keyed by column
+// name. NULLs are written as null, and values spanning lines as literal block
+// scalars. Numbers and booleans are written bare, as in the typed json format,
+// and other values are quoted when they'd otherwise read as something else.
+func writeYAML(w io.Writer, result *protocol.QueryResult) error {
+	columns := uniqueColumns(result.Columns)
+	rows := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, row := range result.Rows {
+		mapping := &yaml.Node{Kind: yaml.MappingNode}
+		for i, col := range columns {
+			kind := ""
+			if i < len(result.ColumnTypes) {
+				kind = result.ColumnTypes[i]
+			}
+			mapping.Content = append(mapping.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: col},
+				yamlValue(rowValue(row, i), kind))
+		}
+		rows.Content = append(rows.Content, mapping)
+	}
+	if len(rows.Content) == 0 {
+		rows.Style = yaml.FlowStyle
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(rows); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// yamlValue returns the YAML node value is written as, for a column of the
+// given kind.
+func yamlValue(value, kind string) *yaml.Node {
+	switch {
+	case isNull(value):
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case strings.Contains(value, "\n"):
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.LiteralStyle}
+	}
+	if literal, ok := jsonValue(value, kind); ok {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: literal}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// writeXML writes result as a <results> element holding a <row> element per
+// row, with an element per column named after it. NULLs are written as empty
+// elements marked xsi:nil.
+func writeXML(w io.Writer, result *protocol.QueryResult) error {
+	names := make([]string, len(result.Columns))
+	for i, col := range result.Columns {
+		names[i] = xmlName(col)
+	}
+	names = uniqueColumns(names)
+
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	out.WriteString(`<results xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` + "\n")
+	for _, row := range result.Rows {
+		out.WriteString("  <row>")
+		for i, name := range names {
+			value := rowValue(row, i)
+			if isNull(value) {
+				fmt.Fprintf(&out, `<%s xsi:nil="true"/>`, name)
+				continue
+			}
+			fmt.Fprintf(&out, "<%s>", name)
+			if err := xml.EscapeText(&out, []byte(value)); err != nil {
+				return err
+			}
+			fmt.Fprintf(&out, "</%s>", name)
+		}
+		out.WriteString("</row>\n")
+	}
+	out.WriteString("</results>\n")
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// htmlStyle is the stylesheet of the documents written by writeHTML.
+const htmlStyle = `table { border-collapse: collapse; font-family: sans-serif; font-size: 14px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; white-space: pre-wrap; }
+th { background: #f0f0f0; }
+tr:nth-child(even) td { background: #fafafa; }
+td.null { color: #999; font-style: italic; }`
+
+// writeHTML writes result as a complete HTML document holding a table with a
+// header row of the column names. NULLs are written as NULL in a cell of the
+// null class, so they can be told apart from the text "NULL".
+func writeHTML(w io.Writer, result *protocol.QueryResult) error {
+	var out bytes.Buffer
+	out.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Query results</title>\n")
+	out.WriteString("<style>\n" + htmlStyle + "\n</style>\n</head>\n<body>\n<table>\n")
+
+	out.WriteString("<thead>\n<tr>")
+	for _, col := range result.Columns {
+		out.WriteString("<th>" + html.EscapeString(col) + "</th>")
+	}
+	out.WriteString("</tr>\n</thead>\n<tbody>\n")
+
+	for _, row := range result.Rows {
+		out.WriteString("<tr>")
+		for i := range result.Columns {
+			value := rowValue(row, i)
+			if isNull(value) {
+				out.WriteString(`<td class="null">NULL</td>`)
+				continue
+			}
+			out.WriteString("<td>" + html.EscapeString(value) + "</td>")
+		}
+		out.WriteString("</tr>\n")
+	}
+	out.WriteString("</tbody>\n</table>\n</body>\n</html>\n")
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// xmlName makes a column name usable as an XML element name: characters that
+// aren't allowed are replaced with underscores, and names that don't start
+// with a letter or underscore, or that start with the reserved "xml", are
+// prefixed with one.
+func xmlName(col string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, col)
+
+	first, _ := utf8.DecodeRuneInString(name)
+	if name == "" || !(unicode.IsLetter(first) || first == '_') ||
+		strings.HasPrefix(strings.ToLower(name), "xml") {
+		name = "_" + name
+	}
+	return name
+}
+
+// valueFormat reformats a single value for display, reporting false if the
+// value isn't one it can format, such as text in a currency column.
+type valueFormat func(value string) (string, bool)
+
+// valueFormats are the formatting rules that \fmt can apply to a column.
+var valueFormats = map[string]valueFormat{
+	"currency": func(value string) (string, bool) {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", false
+		}
+		sign := ""
+		if n < 0 {
+			sign, n = "-", -n
+		}
+		return sign + "$" + groupThousands(strconv.FormatFloat(n, 'f', 2, 64)), true
+	},
+	"percent": func(value string) (string, bool) {
+		// values are taken to be fractions, so 0.25 is 25%
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatFloat(n*100, 'f', 1, 64) + "%", true
+	},
+	"thousands": func(value string) (string, bool) {
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", false
+		}
+		sign, digits := "", value
+		if strings.HasPrefix(digits, "-") {
+			sign, digits = "-", digits[1:]
+		}
+		return sign + groupThousands(digits), true
+	},
+	"bytes": func(value string) (string, bool) {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", false
+		}
+		if math.Abs(n) < 1024 {
+			return value + " B", true
+		}
+		units := []string{"KiB", "MiB", "GiB", "TiB", "PiB"}
+		unit := -1
+		for math.Abs(n) >= 1024 && unit < len(units)-1 {
+			n /= 1024
+			unit++
+		}
+		return fmt.Sprintf("%.1f %s", n, units[unit]), true
+	},
+	"date": func(value string) (string, bool) {
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t.Format(time.DateOnly), true
+			}
+		}
+		return "", false
+	},
+}
+
+// dateLayouts are the ways drivers' dates and timestamps come out as text.
+var dateLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST", // time.Time
+	time.RFC3339Nano,
+	time.DateTime,
+	"2006-01-02T15:04:05",
+	time.DateOnly,
+}
+
+// groupThousands puts commas between the thousands of the integer part of an
+// unsigned decimal number, e.g. 1234567.5 becomes 1,234,567.5.
+func groupThousands(number string) string {
+	integer, fraction, hasFraction := strings.Cut(number, ".")
+	var grouped strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	if hasFraction {
+		grouped.WriteString("." + fraction)
+	}
+	return grouped.String()
+}
+
+// formatColumns returns a copy of result for display with the formatting rule
+// for each column, keyed by column name, applied to its values. Values the
+// rule can't format, and NULLs, are left as they are.
+func formatColumns(result *protocol.QueryResult, rules map[string]string) *protocol.QueryResult {
+	formats := make([]valueFormat, len(result.Columns))
+	found := false
+	for i, col := range result.Columns {
+		if rule, ok := rules[col]; ok {
+			formats[i] = valueFormats[rule]
+			found = true
+		}
+	}
+	if !found {
+		return result
+	}
+
+	formatted := &protocol.QueryResult{
+		Columns:     result.Columns,
+		ColumnTypes: result.ColumnTypes,
+		Message:     result.Message,
+		Error:       result.Error,
+	}
+	for _, row := range result.Rows {
+		values := make([]string, len(row.Values))
+		for i, value := range row.Values {
+			values[i] = value
+			if i < len(formats) && formats[i] != nil && !isNull(value) {
+				if display, ok := formats[i](value); ok {
+					values[i] = display
+				}
+			}
+		}
+		formatted.Rows = append(formatted.Rows, &protocol.Row{Values: values})
+	}
+	return formatted
+}
+
+// displayFormats are the output formats meant to be read by people, which
+// numbers are shown in the -locale's style in. The rest are left alone for
+// the programs that read them.
+var displayFormats = map[string]bool{"table": true, "vertical": true, "html": true}
+
+// localizeNumbers returns a copy of result for display with the values of its
+// integer and number columns written the way printer's locale writes them,
+// with its digit grouping and decimal point. Columns with a \fmt rule are
+// left to it.
+func localizeNumbers(result *protocol.QueryResult, printer *message.Printer, rules map[string]string) *protocol.QueryResult {
+	numeric := make([]bool, len(result.Columns))
+	found := false
+	for i, col := range result.Columns {
+		if _, ok := rules[col]; ok || i >= len(result.ColumnTypes) {
+			continue
+		}
+		if kind := result.ColumnTypes[i]; kind == "integer" || kind == "number" {
+			numeric[i] = true
+			found = true
+		}
+	}
+	if !found {
+		return result
+	}
+
+	localized := &protocol.QueryResult{
+		Columns:     result.Columns,
+		ColumnTypes: result.ColumnTypes,
+		Message:     result.Message,
+		Error:       result.Error,
+	}
+	for _, row := range result.Rows {
+		values := make([]string, len(row.Values))
+		for i, value := range row.Values {
+			values[i] = value
+			if i < len(numeric) && numeric[i] && !isNull(value) {
+				if display, ok := localizeNumber(printer, value); ok {
+					values[i] = display
+				}
+			}
+		}
+		localized.Rows = append(localized.Rows, &protocol.Row{Values: values})
+	}
+	return localized
+}
+
+// localizeNumber writes value the way printer's locale would, keeping as many
+// decimal places as it has. Values with more digits than a float64 holds
+// exactly are left as they are.
+func localizeNumber(printer *message.Printer, value string) (string, bool) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return printer.Sprint(number.Decimal(n)), true
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", false
+	}
+	if strings.ContainsAny(value, "eE") {
+		// drivers write large and small floats with an exponent
+		value = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	digits := strings.Trim(strings.NewReplacer("-", "", "+", "", ".", "").Replace(value), "0")
+	if len(digits) > 15 {
+		return "", false
+	}
+	_, fraction, _ := strings.Cut(value, ".")
+	return printer.Sprint(number.Decimal(f, number.Scale(len(fraction)))), true
+}
+
+// limitRows returns a copy of result for display with only its first limit
+// rows. A limit of zero keeps them all.
+func limitRows(result *protocol.QueryResult, limit int) *protocol.QueryResult {
+	if limit <= 0 || len(result.Rows) <= limit {
+		return result
+	}
+	return &protocol.QueryResult{
+		Columns:     result.Columns,
+		ColumnTypes: result.ColumnTypes,
+		Rows:        result.Rows[:limit],
+		Message:     result.Message,
+		Error:       result.Error,
+	}
+}
+
+// reorderColumns returns a copy of result for display with the named columns
+// first, in the order given, followed by the rest in their original order.
+// Names that aren't in the result are ignored.
+func reorderColumns(result *protocol.QueryResult, order []string) *protocol.QueryResult {
+	if len(order) == 0 {
+		return result
+	}
+
+	used := make([]bool, len(result.Columns))
+	indexes := make([]int, 0, len(result.Columns))
+	for _, name := range order {
+		for i, col := range result.Columns {
+			if !used[i] && col == name {
+				used[i] = true
+				indexes = append(indexes, i)
+				break
+			}
+		}
+	}
+	for i := range result.Columns {
+		if !used[i] {
+			indexes = append(indexes, i)
+		}
+	}
+
+	reordered := &protocol.QueryResult{
+		Columns: make([]string, len(indexes)),
+		Message: result.Message,
+		Error:   result.Error,
+	}
+	for n, i := range indexes {
+		reordered.Columns[n] = result.Columns[i]
+	}
+	if len(result.ColumnTypes) == len(result.Columns) {
+		reordered.ColumnTypes = make([]string, len(indexes))
+		for n, i := range indexes {
+			reordered.ColumnTypes[n] = result.ColumnTypes[i]
+		}
+	}
+	for _, row := range result.Rows {
+		values := make([]string, len(indexes))
+		for n, i := range indexes {
+			values[n] = rowValue(row, i)
+		}
+		reordered.Rows = append(reordered.Rows, &protocol.Row{Values: values})
+	}
+	return reordered
+}
+
+// matchingColumns returns a copy of result with only the columns whose names
+// match pattern.
+func matchingColumns(result *protocol.QueryResult, pattern *regexp.Regexp) *protocol.QueryResult {
+	var indexes []int
+	for i, col := range result.Columns {
+		if pattern.MatchString(col) {
+			indexes = append(indexes, i)
+		}
+	}
+
+	matched := &protocol.QueryResult{Columns: make([]string, len(indexes))}
+	for n, i := range indexes {
+		matched.Columns[n] = result.Columns[i]
+	}
+	if len(result.ColumnTypes) == len(result.Columns) {
+		matched.ColumnTypes = make([]string, len(indexes))
+		for n, i := range indexes {
+			matched.ColumnTypes[n] = result.ColumnTypes[i]
+		}
+	}
+	for _, row := range result.Rows {
+		values := make([]string, len(indexes))
+		for n, i := range indexes {
+			values[n] = rowValue(row, i)
+		}
+		matched.Rows = append(matched.Rows, &protocol.Row{Values: values})
+	}
+	return matched
+}
+
+// matchingRows returns a copy of result with only the rows that have a value
+// matching pattern. NULLs match as they're printed.
+func matchingRows(result *protocol.QueryResult, pattern *regexp.Regexp) *protocol.QueryResult {
+	matched := &protocol.QueryResult{Columns: result.Columns, ColumnTypes: result.ColumnTypes}
+	for _, row := range result.Rows {
+		for _, value := range row.Values {
+			if pattern.MatchString(value) {
+				matched.Rows = append(matched.Rows, row)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// writeTiming writes how long a query took, split into the time until the
+// database had the first rows ready and the time spent fetching them.
+func writeTiming(w io.Writer, result *protocol.QueryResult) {
+	execute := time.Duration(result.ExecuteMicros) * time.Microsecond
+	fetch := time.Duration(result.FetchMicros) * time.Microsecond
+	fmt.Fprintf(w, "Time: %v (execute %v, fetch %v for %d rows)\n",
+		(execute + fetch).Round(time.Microsecond), execute, fetch, len(result.Rows))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"sqlrepl/internal/database"
+)
+
+// healthCheckTimeout bounds how long the readiness check waits on the database.
+const healthCheckTimeout = 5 * time.Second
+
+// runHealthServer serves /healthz, which reports the server is alive, and
+// /readyz, which also pings the database given by -health-db-type and
+// -health-conn if they're set. It runs until the process exits.
+func runHealthServer(port int) {
+	var mu sync.Mutex
+	var dbconn *database.Connection
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if *healthDBType == "" {
+			fmt.Fprintln(w, "ok")
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// connect on first use and keep the connection for later checks
+		if dbconn == nil {
+			conn := &database.Connection{Options: connectionOptions()}
+			if err := conn.Connect(*healthDBType, *healthConn); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			dbconn = conn
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), healthCheckTimeout)
+		defer cancel()
+		if err := dbconn.Ping(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	log.Printf("Health checks listening on %d", port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		log.Printf("Error serving health checks: %v", err)
+	}
+}
+
+// runMetricsServer serves the Prometheus metrics on /metrics. It runs until
+// the process exits.
+func runMetricsServer(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("Metrics listening on %d", port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		log.Printf("Error serving metrics: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sequenceMarker brackets the optional sequence number at the start of a
+// query line: "\x1E42\x1ESELECT ...". Clients that number their statements
+// and send a batch id in the handshake can resume a batch after reconnecting.
+const sequenceMarker = "\x1E"
+
+// batchRegistry remembers, for each client-supplied batch id, the sequence
+// number of the last statement that ran successfully. It is shared by every
+// client connection so that it survives a client reconnecting.
+type batchRegistry struct {
+	mu       sync.Mutex
+	sequence map[string]int64
+}
+
+var batches = &batchRegistry{sequence: map[string]int64{}}
+
+// last returns the sequence number of the last statement completed in batch,
+// or zero if none have.
+func (registry *batchRegistry) last(batch string) int64 {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return registry.sequence[batch]
+}
+
+// complete records that the statement numbered sequence ran successfully.
+func (registry *batchRegistry) complete(batch string, sequence int64) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if sequence > registry.sequence[batch] {
+		registry.sequence[batch] = sequence
+	}
+}
+
+// parseSequence splits the sequence number off the front of a query line,
+// returning zero if the line isn't numbered.
+func parseSequence(line string) (int64, string) {
+	rest, ok := strings.CutPrefix(line, sequenceMarker)
+	if !ok {
+		return 0, line
+	}
+	number, query, ok := strings.Cut(rest, sequenceMarker)
+	if !ok {
+		return 0, line
+	}
+	sequence, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, line
+	}
+	return sequence, query
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"strings"
+	"sync"
+)
+
+// cancelCommand cancels the running or queued query tagged with a correlation
+// id: `\cancel req-7f3a`. It is acted on as soon as it is read, and gets no
+// reply of its own; the cancelled query's result reports the cancellation.
+const cancelCommand = `\cancel`
+
+// maxQueuedQueries is how many queries a client may send ahead of the one
+// running before the server stops reading from it. A cancel sent behind more
+// queries than this waits until there's room.
+const maxQueuedQueries = 64
+
+// request is a line read from the client, with the context the query it
+// holds runs under.
+type request struct {
+	line   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	err    error
+}
+
+// inflightQueries holds the cancel functions of a client's tagged queries
+// from when they're read until they finish. Ids should be unique among a
+// client's queries in flight; a query reusing an id replaces the earlier one.
+type inflightQueries struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// start returns the context for the query tagged with id to run under.
+func (inflight *inflightQueries) start(id string) (context.Context, context.CancelFunc) {
+	if id == "" {
+		// untagged queries can't be cancelled
+		return context.Background(), func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	inflight.mu.Lock()
+	defer inflight.mu.Unlock()
+	inflight.cancels[id] = cancel
+	return ctx, cancel
+}
+
+// finish forgets the query tagged with id.
+func (inflight *inflightQueries) finish(id string) {
+	inflight.mu.Lock()
+	defer inflight.mu.Unlock()
+	delete(inflight.cancels, id)
+}
+
+// cancel cancels the query tagged with id, reporting whether there was one.
+func (inflight *inflightQueries) cancel(id string) bool {
+	inflight.mu.Lock()
+	defer inflight.mu.Unlock()
+	cancel, ok := inflight.cancels[id]
+	if ok {
+		cancel()
+		delete(inflight.cancels, id)
+	}
+	return ok
+}
+
+// readRequests reads lines from the client and queues them on requests until
+// reading fails or done is closed. Cancel commands are carried out straight
+// away rather than queued, so that they can reach a query that's running.
+func readRequests(reader *bufio.Reader, inflight *inflightQueries, requests chan<- request, done <-chan struct{}) {
+	for {
+		line, err := reader.ReadString('\n')
+		req := request{line: line, err: err}
+
+		if err == nil {
+			trimmed := strings.TrimSuffix(line, "\n")
+			if id, ok := strings.CutPrefix(trimmed, cancelCommand+" "); ok {
+				if !inflight.cancel(id) {
+					log.Printf("No query %s to cancel", id)
+				}
+				continue
+			}
+			id, _ := parseCorrelationID(trimmed)
+			req.ctx, req.cancel = inflight.start(id)
+		}
+
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -2,15 +2,19 @@ package client
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"sqlrepl/internal/database"
 	"sqlrepl/internal/protocol"
+	"sqlrepl/internal/querylog"
 
 	"google.golang.org/protobuf/proto"
 )
@@ -19,19 +23,83 @@ import (
 // the whole request really; not a huge deal when running locally, but it's a super
 // big deal if connecting to this server remotely
 
+// Config holds the settings the server applies to every client connection.
+type Config struct {
+	// Options are passed on to each client's database connection
+	Options database.Options
+
+	// Safe rejects destructive statements instead of running them
+	Safe bool
+
+	// QueryLog records every statement run, may be nil
+	QueryLog *querylog.Logger
+
+	// MaxQueriesPerSecond limits how fast each client may run queries;
+	// queries over the limit are delayed. Zero means no limit.
+	MaxQueriesPerSecond float64
+
+	// Filter, if set, rejects queries that it doesn't allow
+	Filter *QueryFilter
+}
+
+// ConfigHolder holds the Config that new client connections are handled
+// with, which can be replaced while the server runs. Connections already open
+// keep the Config they started with.
+type ConfigHolder struct {
+	config atomic.Pointer[Config]
+}
+
+// NewConfigHolder returns a holder for config.
+func NewConfigHolder(config Config) *ConfigHolder {
+	holder := &ConfigHolder{}
+	holder.Store(config)
+	return holder
+}
+
+// Load returns the current Config.
+func (holder *ConfigHolder) Load() Config {
+	return *holder.config.Load()
+}
+
+// Store replaces the Config given to connections from now on.
+func (holder *ConfigHolder) Store(config Config) {
+	holder.config.Store(&config)
+}
+
+// listDriversCommand asks the server for the database types it supports. It
+// may be sent in place of, or after, the connection parameters.
+const listDriversCommand = `\list-drivers`
+
 // Handle manages a single client connection in server mode.
-func Handle(conn net.Conn) {
+func Handle(conn net.Conn, config Config) {
 	defer conn.Close()
 
+	connectionsTotal.Inc()
+	activeConnections.Inc()
+	defer activeConnections.Dec()
+
 	reader := bufio.NewReader(conn)
 
-	// Read the database connection parameters as JSON
-	paramsJSON, err := reader.ReadString('\n')
-	if err != nil {
-		log.Printf("Error reading connection parameters: %v", err)
-		return
+	// Read the database connection parameters as JSON. Before sending them
+	// the client may ask which database types are supported.
+	var paramsJSON string
+	var err error
+	for {
+		paramsJSON, err = reader.ReadString('\n')
+		if err != nil {
+			log.Printf("Error reading connection parameters: %v", err)
+			return
+		}
+		paramsJSON = paramsJSON[:len(paramsJSON)-1] // Trim newline
+
+		if paramsJSON != listDriversCommand {
+			break
+		}
+		if err = sendResult(conn, driversResult()); err != nil {
+			log.Printf("Error sending drivers to client: %v", err)
+			return
+		}
 	}
-	paramsJSON = paramsJSON[:len(paramsJSON)-1] // Trim newline
 
 	var params protocol.DBParams
 	err = json.Unmarshal([]byte(paramsJSON), &params)
@@ -41,19 +109,51 @@ func Handle(conn net.Conn) {
 		return
 	}
 
+	if params.Format != "" && params.Format != csvStreamFormat {
+		sendError(conn, fmt.Sprintf("Unsupported format: %s", params.Format))
+		return
+	}
+
 	// Connect to the database
-	dbconn := database.Connection{}
+	dbconn := database.Connection{Options: config.Options}
 	err = dbconn.Connect(params.Dbtype, params.Connstring)
 	if err != nil {
 		log.Printf("Error connecting to database: %v", err)
+		if errors.Is(err, database.ErrTooManyConnections) {
+			sendError(conn, err.Error())
+			return
+		}
 		sendError(conn, "Failed to connect to database")
 		return
 	}
 	defer dbconn.Close()
 
+	// A client resuming a numbered batch is told how far the batch got
+	// before it lost its connection
+	if params.BatchId != "" {
+		ack := &protocol.QueryResult{LastSequence: batches.last(params.BatchId)}
+		if err = sendResult(conn, ack); err != nil {
+			log.Printf("Error sending batch position to client: %v", err)
+			return
+		}
+	}
+
+	var limiter *tokenBucket
+	if config.MaxQueriesPerSecond > 0 {
+		limiter = newTokenBucket(config.MaxQueriesPerSecond)
+	}
+
+	// Queries are read as they arrive, so that a cancel can be acted on
+	// while an earlier query is still running
+	inflight := &inflightQueries{cancels: map[string]context.CancelFunc{}}
+	requests := make(chan request, maxQueuedQueries)
+	done := make(chan struct{})
+	defer close(done)
+	go readRequests(reader, inflight, requests, done)
+
 	// Handle subsequent queries
-	for {
-		query, err := reader.ReadString('\n')
+	for req := range requests {
+		query, err := req.line, req.err
 		if err != nil {
 			if err == io.EOF {
 				log.Println("Client disconnected")
@@ -64,65 +164,121 @@ func Handle(conn net.Conn) {
 		}
 
 		if len(query) > 0 && query[0] == '\x1D' { // group/batch delimiter
-			// First send the response length so that the client knows how many
-			// bytes to read
-			bytes := []byte("\x1D")
-			lengthBytes := make([]byte, 4)
-			binary.BigEndian.PutUint32(lengthBytes, uint32(len(bytes)))
-
-			_, err = conn.Write(lengthBytes)
-			if err != nil {
-				log.Printf("Error sending length to client: %v", err)
-				return
-			}
-
 			// write out group-delimiter characted to notify the client that
 			// we're finished writing responses for the current batch of
 			// queries
-			conn.Write(bytes)
+			if err = writeFrame(conn, []byte("\x1D")); err != nil {
+				log.Printf("Error sending batch delimiter to client: %v", err)
+				return
+			}
 			continue
 		}
 
 		query = query[:len(query)-1] // Trim newline
-		result := dbconn.ExecuteQuery(query)
+		correlationID, query := parseCorrelationID(query)
+		sequence, query := parseSequence(query)
+
+		// where the query came from, as it appears in the logs
+		source := conn.RemoteAddr().String()
+		if correlationID != "" {
+			source += " " + correlationID
+		}
+
+		var result *protocol.QueryResult
+		var queryID int
+		if query == listDriversCommand {
+			result = driversResult()
+		} else if params.BatchId != "" && sequence > 0 && sequence <= batches.last(params.BatchId) {
+			// the client is retrying a statement that already ran before it
+			// reconnected; don't run it twice
+			result = &protocol.QueryResult{Message: fmt.Sprintf("Statement %d already executed", sequence)}
+		} else if draining.Load() {
+			result = &protocol.QueryResult{Error: "Statement rejected: the server is shutting down"}
+		} else if config.Safe && database.IsDestructive(query) {
+			log.Printf("Rejected destructive statement from %s", source)
+			result = &protocol.QueryResult{Error: "Statement rejected: destructive statements are not allowed in safe mode"}
+		} else if reason := config.Filter.check(query); reason != "" {
+			log.Printf("Rejected statement from %s: %s", source, reason)
+			result = &protocol.QueryResult{Error: "Statement rejected: " + reason}
+		} else {
+			if limiter != nil {
+				if delay := limiter.wait(); delay > 0 {
+					log.Printf("Rate limited %s for %v", source, delay)
+				}
+			}
+			config.QueryLog.Log(source, query)
+			start := time.Now()
+			// the query counts as running until its result is sent
+			queryID = running.start(source)
+			if params.Format == csvStreamFormat {
+				result = streamCSV(req.ctx, conn, &dbconn, query)
+			} else {
+				result = dbconn.ExecuteQueryContext(req.ctx, query)
+			}
+			if req.ctx.Err() == context.Canceled {
+				log.Printf("Query from %s was cancelled", source)
+				result.Error = "Query cancelled"
+			}
+
+			dbType := database.DBTypeString(dbconn.DBType())
+			queriesTotal.WithLabelValues(dbType).Inc()
+			queryDuration.WithLabelValues(dbType).Observe(time.Since(start).Seconds())
+			if result.Error != "" {
+				queryErrorsTotal.WithLabelValues(dbType).Inc()
+			}
+			if correlationID != "" {
+				log.Printf("Query from %s finished in %v", source, time.Since(start))
+			}
+			if params.BatchId != "" && sequence > 0 && result.Error == "" {
+				batches.complete(params.BatchId, sequence)
+			}
+		}
 
 		protoResult := protocol.QueryResult{
-			Columns: result.Columns,
-			Message: result.Message,
-			Error:   result.Error,
+			Columns:  result.Columns,
+			Message:  result.Message,
+			Error:    result.Error,
+			Sequence: sequence,
+
+			CorrelationId: correlationID,
+			ErrorPosition: result.ErrorPosition,
+			ColumnTypes:   result.ColumnTypes,
+			Warnings:      result.Warnings,
+			ExecuteMicros: result.ExecuteMicros,
+			FetchMicros:   result.FetchMicros,
 		}
 
-		for _, row := range result.Rows {
+		for n, row := range result.Rows {
 			protoRow := &protocol.Row{
 				Values: make([]string, len(result.Columns)),
 			}
+			if len(row.Values) != len(result.Columns) {
+				log.Printf("Warning: row %d has %d values for %d columns", n+1, len(row.Values), len(result.Columns))
+			}
 			for i := range result.Columns {
+				if i >= len(row.Values) {
+					protoRow.Values[i] = database.FormatValue(nil)
+					continue
+				}
 				protoRow.Values[i] = fmt.Sprintf("%v", row.Values[i])
 			}
 			protoResult.Rows = append(protoResult.Rows, protoRow)
 		}
 
-		// Marshal the protocol buffer
-		responseBytes, err := proto.Marshal(&protoResult)
-		if err != nil {
-			log.Printf("Error marshaling protocol buffer: %v", err)
-			return
+		// the end of the CSV, which is empty if the query wasn't run
+		if params.Format == csvStreamFormat {
+			err = writeFrame(conn, nil)
 		}
 
-		// First send the response length so that the client knows how many
-		// bytes to read
-		lengthBytes := make([]byte, 4)
-		binary.BigEndian.PutUint32(lengthBytes, uint32(len(responseBytes)))
-
-		log.Printf("Sending protobuf data (length: %d, bytes: %x)", len(responseBytes), lengthBytes)
+		inflight.finish(correlationID)
+		req.cancel()
 
-		_, err = conn.Write(lengthBytes)
-		if err != nil {
-			log.Printf("Error sending length to client: %v", err)
-			return
+		if err == nil {
+			err = sendResult(conn, &protoResult)
+		}
+		if queryID != 0 {
+			running.finish(queryID)
 		}
-
-		_, err = conn.Write(responseBytes)
 		if err != nil {
 			log.Printf("Error sending response to client: %v", err)
 			return
@@ -130,10 +286,39 @@ func Handle(conn net.Conn) {
 	}
 }
 
-// sendError sends a protocol buffer-encoded error message to the client.
+// driversResult lists the supported database types, one per row.
+func driversResult() *protocol.QueryResult {
+	result := &protocol.QueryResult{Columns: []string{"dbtype"}}
+	for _, dbType := range database.SupportedDBTypes() {
+		result.Rows = append(result.Rows, &protocol.Row{Values: []string{dbType}})
+	}
+	return result
+}
+
+// sendResult sends a length-prefixed protocol buffer-encoded result to the
+// client.
+func sendResult(conn net.Conn, result *protocol.QueryResult) error {
+	// Marshal the protocol buffer
+	responseBytes, err := proto.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal protocol buffer: %w", err)
+	}
+
+	log.Printf("Sending protobuf data (length: %d, bytes: %08x)", len(responseBytes), len(responseBytes))
+
+	// The length goes first so that the client knows how many bytes to read
+	if err = writeFrame(conn, responseBytes); err != nil {
+		return fmt.Errorf("failed to send response: %w", err)
+	}
+	return nil
+}
+
+// sendError sends a protocol buffer-encoded error message to the client. It's
+// only sent before the connection is closed, so a failure is just logged.
 func sendError(conn net.Conn, message string) {
 	errorResult := protocol.QueryResult{Error: message}
 	errorBytes, _ := proto.Marshal(&errorResult)
-	conn.Write(errorBytes)
-	conn.Write([]byte("\n"))
+	if err := writeFull(conn, errorBytes, []byte("\n")); err != nil {
+		log.Printf("Error sending error to client: %v", err)
+	}
 }
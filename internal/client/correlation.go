@@ -0,0 +1,23 @@
+package client
+
+import "strings"
+
+// correlationMarker brackets the optional correlation id at the start of a
+// query line, before any sequence number: "\x1Freq-7f3a\x1FSELECT ...". The id
+// is echoed back in the result and included in the server's logs so that the
+// two can be matched up.
+const correlationMarker = "\x1F"
+
+// parseCorrelationID splits the correlation id off the front of a query line,
+// returning an empty id if the line isn't tagged.
+func parseCorrelationID(line string) (string, string) {
+	rest, ok := strings.CutPrefix(line, correlationMarker)
+	if !ok {
+		return "", line
+	}
+	id, query, ok := strings.Cut(rest, correlationMarker)
+	if !ok {
+		return "", line
+	}
+	return id, query
+}
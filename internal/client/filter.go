@@ -0,0 +1,75 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// QueryFilter restricts which queries the server runs. A query must match at
+// least one of the Allow patterns, if there are any, and none of the Deny
+// patterns.
+type QueryFilter struct {
+	Allow []*regexp.Regexp
+	Deny  []*regexp.Regexp
+}
+
+// LoadQueryFilter reads a filter from a JSON file of the form
+// {"allow": ["(?i)^\\s*select\\b"], "deny": ["(?i)\\bpg_sleep\\b"]}.
+func LoadQueryFilter(path string) (*QueryFilter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns struct {
+		Allow []string `json:"allow"`
+		Deny  []string `json:"deny"`
+	}
+	if err = json.Unmarshal(data, &patterns); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	filter := &QueryFilter{}
+	if filter.Allow, err = compilePatterns(patterns.Allow); err != nil {
+		return nil, fmt.Errorf("bad allow pattern in %s: %w", path, err)
+	}
+	if filter.Deny, err = compilePatterns(patterns.Deny); err != nil {
+		return nil, fmt.Errorf("bad deny pattern in %s: %w", path, err)
+	}
+	return filter, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		var err error
+		if compiled[i], err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+// check returns the reason query isn't allowed, or "" if it is. A nil filter
+// allows everything.
+func (filter *QueryFilter) check(query string) string {
+	if filter == nil {
+		return ""
+	}
+	for _, pattern := range filter.Deny {
+		if pattern.MatchString(query) {
+			return fmt.Sprintf("matches denied pattern %s", pattern)
+		}
+	}
+	if len(filter.Allow) == 0 {
+		return ""
+	}
+	for _, pattern := range filter.Allow {
+		if pattern.MatchString(query) {
+			return ""
+		}
+	}
+	return "doesn't match any allowed pattern"
+}
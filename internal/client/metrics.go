@@ -0,0 +1,31 @@
+package client
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Server metrics, exported by the server's -metrics-port endpoint.
+var (
+	connectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sqlrepl_connections_total",
+		Help: "Client connections accepted.",
+	})
+	activeConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sqlrepl_active_connections",
+		Help: "Client connections currently open.",
+	})
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sqlrepl_queries_total",
+		Help: "Queries executed.",
+	}, []string{"dbtype"})
+	queryErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sqlrepl_query_errors_total",
+		Help: "Queries that returned an error.",
+	}, []string{"dbtype"})
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sqlrepl_query_duration_seconds",
+		Help:    "Time taken to execute queries and read their results.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"dbtype"})
+)
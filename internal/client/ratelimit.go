@@ -0,0 +1,36 @@
+package client
+
+import "time"
+
+// tokenBucket limits how often a client may run queries. It holds up to burst
+// tokens, refilled at rate per second, and each query takes one. It's only
+// used from the goroutine handling a single client, so it isn't locked.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait takes a token, first sleeping until one is available, and returns how
+// long it waited.
+func (bucket *tokenBucket) wait() time.Duration {
+	now := time.Now()
+	bucket.tokens = min(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+
+	var delay time.Duration
+	if bucket.tokens < 1 {
+		delay = time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+		time.Sleep(delay)
+		bucket.tokens = 1
+		bucket.last = time.Now()
+	}
+	bucket.tokens--
+	return delay
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RunningQuery is a query running for a client, as reported by Running.
+type RunningQuery struct {
+	Source  string
+	Started time.Time
+}
+
+// runningRegistry tracks the queries running across every client connection,
+// so that a server shutting down can report what it's waiting for.
+type runningRegistry struct {
+	mu      sync.Mutex
+	next    int
+	queries map[int]RunningQuery
+}
+
+var running = &runningRegistry{queries: map[int]RunningQuery{}}
+
+// start records a query from source as running, returning the id to finish
+// it with.
+func (registry *runningRegistry) start(source string) int {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.next++
+	registry.queries[registry.next] = RunningQuery{Source: source, Started: time.Now()}
+	return registry.next
+}
+
+func (registry *runningRegistry) finish(id int) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.queries, id)
+}
+
+// Running returns the queries running now, the longest running first.
+func Running() []RunningQuery {
+	running.mu.Lock()
+	queries := make([]RunningQuery, 0, len(running.queries))
+	for _, query := range running.queries {
+		queries = append(queries, query)
+	}
+	running.mu.Unlock()
+
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Started.Before(queries[j].Started) })
+	return queries
+}
+
+// draining is set once the server starts shutting down, after which clients'
+// new queries are turned away so that the ones running can finish.
+var draining atomic.Bool
+
+// Drain turns away the queries clients send from now on, for a server that is
+// shutting down once those running have finished.
+func Drain() {
+	draining.Store(true)
+}
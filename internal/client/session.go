@@ -0,0 +1,204 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"sqlrepl/internal/protocol"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// RetryPolicy controls how a Session reconnects when it loses its connection
+// to the server.
+type RetryPolicy struct {
+	// Attempts is how many times to try reconnecting before giving up. Zero
+	// never reconnects.
+	Attempts int
+
+	// Delay is how long to wait before the first attempt, doubled after
+	// each failed attempt up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy rides out a server restart or a brief network outage.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 5, Delay: 500 * time.Millisecond, MaxDelay: 30 * time.Second}
+
+// delay returns how long to wait before the given reconnection attempt,
+// counting from 1.
+func (policy RetryPolicy) delay(attempt int) time.Duration {
+	delay := policy.Delay
+	for i := 1; i < attempt && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 {
+		delay = min(delay, policy.MaxDelay)
+	}
+	return delay
+}
+
+// Session is the client side of a connection to a sqlrepl server. It sends
+// the connection parameters and then runs queries one at a time. If the
+// connection drops it reconnects, sending the parameters again, and resends
+// the query it was running. Queries are numbered within a batch so that the
+// server doesn't run one twice if it finished before the connection dropped.
+type Session struct {
+	network string
+	address string
+	params  *protocol.DBParams
+	retry   RetryPolicy
+
+	conn     net.Conn
+	reader   *bufio.Reader
+	sequence int64
+}
+
+// Dial connects to the server at address and has it open the database given
+// by params.
+func Dial(network, address string, params *protocol.DBParams, retry RetryPolicy) (*Session, error) {
+	params = proto.Clone(params).(*protocol.DBParams)
+	if params.BatchId == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		params.BatchId = hex.EncodeToString(id)
+	}
+
+	session := &Session{network: network, address: address, params: params, retry: retry}
+	if err := session.connect(); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// connect opens the connection to the server and sends the connection
+// parameters.
+func (session *Session) connect() error {
+	conn, err := net.Dial(session.network, session.address)
+	if err != nil {
+		return err
+	}
+
+	paramsJSON, err := json.Marshal(session.params)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err = conn.Write(append(paramsJSON, '\n')); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// the server acknowledges a batch with how far it got, or reports that
+	// it couldn't connect to the database
+	reader := bufio.NewReader(conn)
+	ack, err := readResult(reader)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if ack.Error != "" {
+		conn.Close()
+		return errors.New(ack.Error)
+	}
+
+	session.conn, session.reader = conn, reader
+	return nil
+}
+
+// Query runs a query on the server and returns its result. Queries can't span
+// lines, since the server reads one query per line.
+func (session *Session) Query(query string) (*protocol.QueryResult, error) {
+	if strings.ContainsAny(query, "\r\n") {
+		return nil, fmt.Errorf("queries sent to a server can't span lines")
+	}
+
+	session.sequence++
+	line := fmt.Sprintf("%s%d%s%s\n", sequenceMarker, session.sequence, sequenceMarker, query)
+
+	result, err := session.send(line)
+	for attempt := 1; err != nil && attempt <= session.retry.Attempts; attempt++ {
+		delay := session.retry.delay(attempt)
+		log.Printf("Lost the connection to %s (%v), reconnecting in %v (attempt %d of %d)",
+			session.address, err, delay, attempt, session.retry.Attempts)
+		time.Sleep(delay)
+
+		session.conn.Close()
+		if err = session.connect(); err == nil {
+			result, err = session.send(line)
+		}
+	}
+	return result, err
+}
+
+// send writes a query line to the server and reads the result.
+func (session *Session) send(line string) (*protocol.QueryResult, error) {
+	if _, err := io.WriteString(session.conn, line); err != nil {
+		return nil, err
+	}
+	return readResult(session.reader)
+}
+
+// Reconnect closes the connection to the server and opens it again.
+func (session *Session) Reconnect() error {
+	session.conn.Close()
+	return session.connect()
+}
+
+// Close closes the connection to the server, which closes the database
+// connection.
+func (session *Session) Close() error {
+	return session.conn.Close()
+}
+
+// errorFieldTag is the first byte of an encoded QueryResult holding only an
+// error, as sent unframed by sendError.
+const errorFieldTag = 4<<3 | 2
+
+// readResult reads a length-prefixed result from the server. Errors from the
+// handshake are sent unframed, followed by a newline; they're told apart by
+// their first byte, which would be the high byte of an impossibly large
+// length.
+func readResult(reader *bufio.Reader) (*protocol.QueryResult, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if first[0] == errorFieldTag {
+		// the server hangs up after sending it
+		if data, err = io.ReadAll(reader); err != nil {
+			return nil, err
+		}
+		data = bytes.TrimSuffix(data, []byte("\n"))
+	} else {
+		lengthBytes := make([]byte, 4)
+		if _, err = io.ReadFull(reader, lengthBytes); err != nil {
+			return nil, err
+		}
+		data = make([]byte, binary.BigEndian.Uint32(lengthBytes))
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+	}
+
+	var result protocol.QueryResult
+	if err = proto.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+	return &result, nil
+}
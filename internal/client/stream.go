@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"net"
+	"time"
+
+	"sqlrepl/internal/database"
+	"sqlrepl/internal/protocol"
+)
+
+// csvStreamFormat is the DBParams format that has query results streamed as
+// CSV. Each query's rows are sent as one or more length-prefixed chunks of CSV,
+// starting with a header line, followed by a zero-length frame and then the
+// usual QueryResult carrying only the message and error.
+const csvStreamFormat = "csv-stream"
+
+// csvChunkSize is roughly how many bytes of CSV are sent in each frame.
+const csvChunkSize = 64 * 1024
+
+// streamCSV runs query and streams its rows to the client as CSV chunks. NULLs
+// are written as empty fields. It doesn't send the zero-length frame that ends
+// the chunks.
+func streamCSV(ctx context.Context, conn net.Conn, dbconn *database.Connection, query string) *protocol.QueryResult {
+	chunks := &chunkWriter{conn: conn}
+	writer := csv.NewWriter(chunks)
+
+	message, err := dbconn.StreamQuery(ctx, query,
+		func(columns []string) error {
+			// statements that return no rows have no header either
+			if len(columns) == 0 {
+				return nil
+			}
+			return writer.Write(columns)
+		},
+		func(values []any) error {
+			record := make([]string, len(values))
+			for i, val := range values {
+				if val != nil {
+					record[i] = database.FormatValue(val)
+				}
+			}
+			return writer.Write(record)
+		})
+
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err == nil {
+		err = chunks.flush()
+	}
+
+	result := &protocol.QueryResult{Message: message}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// chunkWriter collects CSV and sends it to the client in length-prefixed
+// frames of about csvChunkSize bytes.
+type chunkWriter struct {
+	conn   net.Conn
+	buffer bytes.Buffer
+}
+
+func (chunks *chunkWriter) Write(p []byte) (int, error) {
+	chunks.buffer.Write(p)
+	if chunks.buffer.Len() >= csvChunkSize {
+		if err := chunks.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flush sends whatever has been collected as a frame. Nothing is sent if the
+// buffer is empty, since a zero-length frame ends the stream.
+func (chunks *chunkWriter) flush() error {
+	if chunks.buffer.Len() == 0 {
+		return nil
+	}
+	err := writeFrame(chunks.conn, chunks.buffer.Bytes())
+	chunks.buffer.Reset()
+	return err
+}
+
+// writeFrame sends data to the client prefixed by its length.
+func writeFrame(conn net.Conn, data []byte) error {
+	lengthBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(lengthBytes, uint32(len(data)))
+	return writeFull(conn, lengthBytes, data)
+}
+
+// writeTimeout is how long a write to a client may block before the client is
+// given up on as stuck.
+const writeTimeout = 30 * time.Second
+
+// writeFull writes each of buffers to the client in full. Any error leaves the
+// client part way through a frame, so the connection can't be used after it.
+func writeFull(conn net.Conn, buffers ...[]byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	for _, buffer := range buffers {
+		for len(buffer) > 0 {
+			n, err := conn.Write(buffer)
+			if err != nil {
+				return err
+			}
+			buffer = buffer[n:]
+		}
+	}
+	return nil
+}
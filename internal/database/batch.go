@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// BatchFailure is an execution of a batch that failed, by the index of the
+// bind arguments it was given.
+type BatchFailure struct {
+	Index int
+	Err   error
+}
+
+// batchSavepoint is set before each execution of a batch on the databases
+// where a failed statement would abort the rest of the transaction.
+const batchSavepoint = "sqlrepl_batch"
+
+// ExecuteBatch runs statement, written with the driver's own placeholders,
+// once for each set of bind arguments in params. They all run in one
+// transaction: the open one if there is one, which is left open, or else one
+// of its own, which is committed only if every execution succeeded. A failed
+// execution doesn't stop the rest, so that all the failures are found; for
+// postgres each runs after a savepoint that a failure is rolled back to,
+// since it would otherwise abort the transaction. It returns the rows the
+// executions changed and those that failed, or an error if the batch
+// couldn't be run at all.
+func (conn *Connection) ExecuteBatch(statement string, params [][]any) (int64, []BatchFailure, error) {
+	if conn.Options.ReadOnly && IsWrite(statement) {
+		return 0, nil, fmt.Errorf("statement rejected: the connection is read-only")
+	}
+	conn.preQuery(&statement)
+	conn.sent(statement)
+
+	tx := conn.tx
+	if tx == nil {
+		var err error
+		if tx, err = conn.db.BeginTx(conn.context, nil); err != nil {
+			return 0, nil, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+	}
+
+	stmt, err := tx.PrepareContext(conn.context, statement)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer stmt.Close()
+
+	savepoints := conn.dbType == DriverPostgreSQL
+	var affected int64
+	var failures []BatchFailure
+	for i, args := range params {
+		if err := conn.executeBatchRow(tx, stmt, args, savepoints, &affected); err != nil {
+			failures = append(failures, BatchFailure{Index: i, Err: err})
+		}
+	}
+
+	if tx != conn.tx && len(failures) == 0 {
+		if err = tx.Commit(); err != nil {
+			return 0, nil, fmt.Errorf("failed to commit: %w", err)
+		}
+	}
+	return affected, failures, nil
+}
+
+// executeBatchRow runs one execution of a batch, adding the rows it changed
+// to affected.
+func (conn *Connection) executeBatchRow(tx *sql.Tx, stmt *sql.Stmt, args []any, savepoint bool, affected *int64) error {
+	ctx, cancelFunc := context.WithTimeout(conn.context, conn.queryTimeout())
+	defer cancelFunc()
+
+	if savepoint {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT "+batchSavepoint); err != nil {
+			return err
+		}
+	}
+	result, err := stmt.ExecContext(ctx, args...)
+	if savepoint {
+		release := "RELEASE SAVEPOINT " + batchSavepoint
+		if err != nil {
+			release = "ROLLBACK TO SAVEPOINT " + batchSavepoint
+		}
+		if _, releaseErr := tx.ExecContext(ctx, release); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil {
+		*affected += n
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package database
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// keywords returns the upper-cased words of a SQL statement, skipping over
+// comments, quoted strings, and quoted identifiers. It's a rough tokenizer,
+// only meant for telling what kind of statement something is.
+func keywords(query string) []string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, strings.ToUpper(word.String()))
+			word.Reset()
+		}
+	}
+
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			flush()
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			flush()
+			for i += 3; i < len(runes) && !(runes[i-1] == '*' && runes[i] == '/'); i++ {
+			}
+		case c == '\'' || c == '"' || c == '`':
+			flush()
+			for i++; i < len(runes) && runes[i] != c; i++ {
+			}
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			word.WriteRune(c)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+// IsDestructive reports whether a statement drops or truncates an object, or
+// deletes or updates rows without a WHERE clause, or with one that's always
+// true, like WHERE 1=1. In a WITH statement the main statement and those in
+// its common table expressions are all checked.
+func IsDestructive(query string) bool {
+	statement := tokens(query)
+	if len(statement) == 0 {
+		return false
+	}
+
+	switch statement[0] {
+	case "DROP", "TRUNCATE":
+		return true
+	case "DELETE", "UPDATE":
+		return unfiltered(statement)
+	case "WITH":
+		// the statements are the bodies of the common table expressions,
+		// each starting just inside a parenthesis, and the first one
+		// outside them all
+		depth := 0
+		for i, token := range statement {
+			switch token {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			case "SELECT", "INSERT", "UPDATE", "DELETE", "MERGE":
+				if depth > 0 && statement[i-1] != "(" {
+					continue
+				}
+				if (token == "DELETE" || token == "UPDATE") && unfiltered(statement[i:]) {
+					return true
+				}
+				if depth == 0 {
+					return false
+				}
+			}
+		}
+	}
+	return false
+}
+
+// unfiltered reports whether a DELETE or UPDATE, given as the tokens from
+// its first word to the end of the query, changes every row: its WHERE
+// clause is missing or always true. The statement ends at the parenthesis
+// closing the common table expression it's in, if it's in one.
+func unfiltered(statement []string) bool {
+	var where []string
+	inWhere := false
+	depth := 0
+	for _, token := range statement {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth < 0 {
+			break
+		}
+		if depth == 0 {
+			switch token {
+			case "WHERE":
+				inWhere = true
+				continue
+			case "RETURNING", "ORDER", "LIMIT", "OPTION", ";":
+				inWhere = false
+			}
+		}
+		if inWhere {
+			where = append(where, token)
+		}
+	}
+	return len(where) == 0 || alwaysTrue(where)
+}
+
+// alwaysTrue reports whether a condition is one that every row meets, such
+// as TRUE, 1, or 1=1, or a column compared with itself, either on its own or
+// as one side of an OR.
+func alwaysTrue(condition []string) bool {
+	for _, alternative := range splitTopLevel(condition, "OR") {
+		terms := splitTopLevel(alternative, "AND")
+		trivial := true
+		for _, term := range terms {
+			trivial = trivial && trivialTerm(term)
+		}
+		if trivial {
+			return true
+		}
+	}
+	return false
+}
+
+// trivialTerm reports whether a single term of a condition is always true.
+func trivialTerm(term []string) bool {
+	for parenthesized(term) {
+		term = term[1 : len(term)-1]
+	}
+	if len(term) == 1 {
+		if term[0] == "TRUE" {
+			return true
+		}
+		n, err := strconv.ParseFloat(term[0], 64)
+		return err == nil && n != 0
+	}
+	if len(term) == 2 && term[0] == "NOT" && (term[1] == "FALSE" || term[1] == "0") {
+		return true
+	}
+
+	// the same thing on either side of =
+	for i, token := range term {
+		if token == "=" && i > 0 && i < len(term)-1 && !strings.Contains("<>!", term[i-1]) {
+			return slices.Equal(term[:i], term[i+1:])
+		}
+	}
+	return false
+}
+
+// parenthesized reports whether tokens are all inside one pair of
+// parentheses, like (a = 1), but not (a) = (b).
+func parenthesized(tokens []string) bool {
+	if len(tokens) < 2 || tokens[0] != "(" {
+		return false
+	}
+	depth := 0
+	for i, token := range tokens {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i == len(tokens)-1
+			}
+		}
+	}
+	return false
+}
+
+// splitTopLevel splits tokens at each sep that isn't inside parentheses.
+func splitTopLevel(tokens []string, sep string) [][]string {
+	var parts [][]string
+	depth, start := 0, 0
+	for i, token := range tokens {
+		switch {
+		case token == "(":
+			depth++
+		case token == ")":
+			depth--
+		case token == sep && depth == 0:
+			parts = append(parts, tokens[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, tokens[start:])
+}
+
+// tokens splits a SQL statement into its words, upper-cased, its quoted
+// strings and identifiers, as written, and the symbols between them, one
+// character each, skipping comments and whitespace. Like keywords, it's
+// only meant for telling what a statement does.
+func tokens(query string) []string {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			for i += 3; i < len(runes) && !(runes[i-1] == '*' && runes[i] == '/'); i++ {
+			}
+		case c == '\'' || c == '"' || c == '`':
+			start := i
+			for i++; i < len(runes) && runes[i] != c; i++ {
+			}
+			tokens = append(tokens, string(runes[start:min(i+1, len(runes))]))
+		case c == '_' || c == '.' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			start := i
+			for i+1 < len(runes) && (runes[i+1] == '_' || runes[i+1] == '.' || unicode.IsLetter(runes[i+1]) || unicode.IsDigit(runes[i+1])) {
+				i++
+			}
+			tokens = append(tokens, strings.ToUpper(string(runes[start:i+1])))
+		case !unicode.IsSpace(c):
+			tokens = append(tokens, string(c))
+		}
+	}
+	return tokens
+}
+
+// IsExplainable reports whether a statement is one that EXPLAIN accepts.
+func IsExplainable(query string) bool {
+	words := keywords(query)
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "SELECT", "WITH", "INSERT", "UPDATE", "DELETE":
+		return true
+	}
+	return false
+}
+
+// writeKeywords are the statements that change data or schema, or that may:
+// blocks and procedure calls can do anything, and a transaction begun with
+// BEGIN can be made read-write.
+var writeKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "UPSERT": true, "REPLACE": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true,
+	"GRANT": true, "REVOKE": true, "COMMENT": true, "COPY": true, "LOAD": true,
+	"BEGIN": true, "DECLARE": true, "DO": true, "EXEC": true, "EXECUTE": true, "CALL": true,
+}
+
+// IsWrite reports whether a statement looks like it changes data or schema.
+// A WITH statement counts if any of its parts is a write. So does a query that
+// locks the rows it reads, like SELECT ... FOR UPDATE, or that writes them
+// somewhere, like SELECT ... INTO, and a SET that changes whether transactions
+// are read-only.
+func IsWrite(query string) bool {
+	words := keywords(query)
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "WITH", "SELECT":
+		for i, word := range words[1:] {
+			if word == "INTO" || word == "FOR" && i+2 < len(words) && lockModes[words[i+2]] {
+				return true
+			}
+			if words[0] == "WITH" && (word == "INSERT" || word == "UPDATE" || word == "DELETE" || word == "MERGE") {
+				return true
+			}
+		}
+		return false
+	case "SET", "START":
+		for _, word := range words[1:] {
+			if strings.Contains(word, "TRANSACTION") || strings.Contains(word, "READ_ONLY") {
+				return true
+			}
+		}
+		return false
+	}
+	return writeKeywords[words[0]]
+}
+
+// lockModes are the words that follow FOR in a query that locks the rows it
+// reads, as in FOR UPDATE, FOR SHARE or FOR NO KEY UPDATE.
+var lockModes = map[string]bool{"UPDATE": true, "SHARE": true, "NO": true, "KEY": true}
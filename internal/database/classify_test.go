@@ -0,0 +1,87 @@
+package database
+
+import "testing"
+
+func TestIsDestructive(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"DROP TABLE t", true},
+		{"truncate t", true},
+		{"SELECT * FROM t", false},
+		{"DELETE FROM t", true},
+		{"DELETE FROM t WHERE id = 3", false},
+		{"UPDATE t SET a = 1 WHERE a <> 1", false},
+		{"UPDATE t SET a = 1 WHERE a = 1 RETURNING *", false},
+
+		// a WHERE clause that every row passes
+		{"DELETE FROM t WHERE 1=1", true},
+		{"delete from t where (1 = 1)", true},
+		{"DELETE FROM t WHERE true", true},
+		{"DELETE FROM t WHERE 1", true},
+		{"DELETE FROM t WHERE 0", false},
+		{"DELETE FROM t WHERE id = id", true},
+		{"DELETE FROM t WHERE 'a' = 'a' AND 2 = 2", true},
+		{"DELETE FROM t WHERE id = 3 OR 1 = 1", true},
+		{"DELETE FROM t WHERE id >= id", false},
+		{"DELETE FROM t WHERE (a) = (b)", false},
+		{"UPDATE t SET a = (SELECT 1 FROM u WHERE u.x = 1)", true},
+
+		// WITH statements
+		{"WITH x AS (SELECT 1) DELETE FROM t", true},
+		{"WITH x AS (SELECT 1) DELETE FROM t WHERE id IN (SELECT * FROM x)", false},
+		{"WITH x AS (SELECT 1) UPDATE t SET a = 1 FROM x", true},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", true},
+		{"WITH d AS (DELETE FROM t WHERE id = 1 RETURNING *) SELECT * FROM d", false},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", false},
+		{"WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x ON CONFLICT (id) DO UPDATE SET a = 1", false},
+
+		// UPDATE that isn't a statement of its own
+		{"SELECT * FROM t FOR UPDATE", false},
+		{"INSERT INTO t VALUES (1) ON CONFLICT (id) DO UPDATE SET a = 1", false},
+	}
+	for _, test := range tests {
+		if got := IsDestructive(test.query); got != test.want {
+			t.Errorf("IsDestructive(%q) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestIsWrite(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT * FROM t", false},
+		{"INSERT INTO t VALUES (1)", true},
+		{"with x as (select 1) select * from x", false},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", true},
+
+		// blocks, procedures and transactions that may write
+		{"BEGIN READ WRITE", true},
+		{"BEGIN DELETE FROM t; END;", true},
+		{"DECLARE x NUMBER; BEGIN NULL; END;", true},
+		{"DO $$ BEGIN DELETE FROM t; END $$", true},
+		{"EXEC dbo.purge", true},
+		{"EXECUTE purge_plan", true},
+		{"CALL purge()", true},
+		{"START TRANSACTION READ WRITE", true},
+		{"SET TRANSACTION READ WRITE", true},
+		{"SET SESSION CHARACTERISTICS AS TRANSACTION READ WRITE", true},
+		{"SET default_transaction_read_only = off", true},
+		{"SET search_path = sales", false},
+
+		// queries that lock or write the rows they read
+		{"SELECT * FROM t FOR UPDATE", true},
+		{"SELECT * FROM t FOR NO KEY UPDATE SKIP LOCKED", true},
+		{"SELECT * FROM t FOR SHARE", true},
+		{"SELECT * INTO t2 FROM t", true},
+		{"SELECT 'for update' FROM t", false},
+	}
+	for _, test := range tests {
+		if got := IsWrite(test.query); got != test.want {
+			t.Errorf("IsWrite(%q) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
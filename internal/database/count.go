@@ -0,0 +1,201 @@
+package database
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// word is an upper-cased keyword or identifier of a statement, with where it
+// starts and ends in the statement.
+type word struct {
+	text       string
+	start, end int
+}
+
+// topLevelWords returns the words of a statement outside of parentheses,
+// comments, and quotes, along with the offset of each top-level comma as a
+// word of its own, so that a statement can be cut at its clauses.
+func topLevelWords(query string) []word {
+	var words []word
+	depth, start := 0, -1
+	flush := func(end int) {
+		if start >= 0 {
+			if depth == 0 {
+				words = append(words, word{strings.ToUpper(query[start:end]), start, end})
+			}
+			start = -1
+		}
+	}
+
+	for i := 0; i < len(query); {
+		c, size := utf8.DecodeRuneInString(query[i:])
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			flush(i)
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				size = end + 1
+			} else {
+				size = len(query) - i
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			flush(i)
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				size = end + 4
+			} else {
+				size = len(query) - i
+			}
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			flush(i)
+			closing := byte(c)
+			if c == '[' {
+				closing = ']'
+			}
+			if end := strings.IndexByte(query[i+1:], closing); end >= 0 {
+				size = end + 2
+			} else {
+				size = len(query) - i
+			}
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			if start < 0 {
+				start = i
+			}
+		default:
+			flush(i)
+			switch c {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case ',':
+				if depth == 0 {
+					words = append(words, word{",", i, i + 1})
+				}
+			}
+		}
+		i += size
+	}
+	flush(len(query))
+	return words
+}
+
+// trailingClauses are the clauses, by database type, that may follow the
+// WHERE clause of an UPDATE or DELETE and have no place in a count of the rows
+// it affects.
+var trailingClauses = map[int][]string{
+	DriverPostgreSQL: {"RETURNING"},
+	DriverMySQL:      {"ORDER", "LIMIT"},
+	DriverSQLite:     {"RETURNING", "ORDER", "LIMIT"},
+	DriverOracle:     {"RETURNING", "RETURN", "LOG"},
+	DriverSqlServer:  {"OPTION"},
+	DriverSnowflake:  {},
+	DriverDuckDB:     {"RETURNING"},
+}
+
+// unsupportedWords are the words that mark an UPDATE or DELETE as reaching
+// beyond a single table, such as one joined to others, or one limited to its
+// first rows, whose affected rows can't be counted by its WHERE clause alone.
+var unsupportedWords = map[string]bool{
+	"JOIN": true, "USING": true, "FROM": true, ",": true, "TOP": true, "OUTPUT": true, "OR": true,
+}
+
+// CountQuery rewrites an UPDATE or DELETE of a single table into a SELECT
+// COUNT(*) of the rows it would change, from its table and WHERE clause. It
+// reports false for other statements and for those that it can't rewrite,
+// such as ones that join other tables.
+func CountQuery(query string, dbType int) (string, bool) {
+	trailing, ok := trailingClauses[dbType]
+	if !ok {
+		return "", false
+	}
+	words := topLevelWords(query)
+	if len(words) < 2 {
+		return "", false
+	}
+	ends := append([]string{"WHERE"}, trailing...)
+
+	// the table, with any alias, runs from tableStart to tableEnd, and rest
+	// are the words from the WHERE clause on
+	var tableStart, tableEnd int
+	var tableWords, rest []word
+	switch words[0].text {
+	case "DELETE":
+		tableStart, rest = words[0].end, words[1:]
+		if rest[0].text == "FROM" {
+			tableStart, rest = rest[0].end, rest[1:]
+		} else if dbType != DriverOracle {
+			// only oracle lets FROM be left out
+			return "", false
+		}
+		n := clauseEnd(rest, ends)
+		tableWords, rest = rest[:n], rest[n:]
+		tableEnd = len(query)
+		if len(rest) > 0 {
+			tableEnd = rest[0].start
+		}
+	case "UPDATE":
+		set := indexWord(words, "SET")
+		if set < 0 {
+			return "", false
+		}
+		tableStart, tableEnd = words[0].end, words[set].start
+		tableWords, rest = words[1:set], words[set+1:]
+
+		// a FROM after SET joins other tables
+		n := clauseEnd(rest, ends)
+		if indexWord(rest[:n], "FROM") >= 0 {
+			return "", false
+		}
+		rest = rest[n:]
+	default:
+		return "", false
+	}
+
+	table := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query[tableStart:tableEnd]), ";"))
+	if table == "" || hasUnsupportedWord(tableWords) {
+		return "", false
+	}
+	count := "SELECT COUNT(*) FROM " + table
+	if len(rest) > 0 && rest[0].text == "WHERE" {
+		whereEnd := len(query)
+		if n := clauseEnd(rest[1:], trailing); n < len(rest)-1 {
+			whereEnd = rest[n+1].start
+		}
+		where := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query[rest[0].end:whereEnd]), ";"))
+		if where == "" {
+			return "", false
+		}
+		count += " WHERE " + where
+	}
+	return count, true
+}
+
+// clauseEnd returns the index of the first of words that starts one of the
+// clauses in ends, or len(words) if none do.
+func clauseEnd(words []word, ends []string) int {
+	for i, w := range words {
+		if slices.Contains(ends, w.text) {
+			return i
+		}
+	}
+	return len(words)
+}
+
+func indexWord(words []word, text string) int {
+	for i, w := range words {
+		if w.text == text {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasUnsupportedWord(words []word) bool {
+	for _, w := range words {
+		if unsupportedWords[w.text] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"sqlrepl/internal/protocol"
+)
+
+// Cursor reads the rows of a query a page at a time, keeping the query open
+// in between, so that a large result can be looked through without reading it
+// all or running the query again. It's opened with OpenCursor and must be
+// closed, which stops the query if rows are left. Nothing else should run on
+// the connection while it's open.
+type Cursor struct {
+	conn      *Connection
+	cancel    context.CancelFunc
+	ctx       context.Context
+	rows      *sql.Rows
+	typeNames []string
+	read      int
+	done      bool
+
+	// pending is set when the rows are on a row that hasn't been read yet
+	pending bool
+
+	// result holds the columns, and Next fills in the rows of each page
+	result TypedResult
+}
+
+// OpenCursor runs query and returns a cursor to read its rows with. The
+// query isn't stopped after the usual time limit, since it stays open for as
+// long as the rows are being looked through.
+func (conn *Connection) OpenCursor(query string) (*Cursor, error) {
+	ctx, cancel := context.WithCancel(conn.context)
+	start := time.Now()
+	rows, err := conn.query(ctx, query)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	cursor := &Cursor{conn: conn, cancel: cancel, ctx: ctx, rows: rows}
+	if cursor.result.Columns, err = rows.Columns(); err == nil {
+		cursor.result.ColumnTypes, err = rows.ColumnTypes()
+	}
+	if err != nil {
+		cursor.Close()
+		return nil, err
+	}
+	cursor.typeNames = columnTypeNames(rows, len(cursor.result.Columns))
+	cursor.result.ExecuteTime = time.Since(start)
+	return cursor, nil
+}
+
+// Next reads up to n more rows. Once the last has been read the page also
+// carries any message or warnings the query produced, and Done reports true.
+// If reading fails, the page holds the rows read before the error.
+func (cursor *Cursor) Next(n int) *protocol.QueryResult {
+	page := cursor.result
+	page.Rows = nil
+	if cursor.read > 0 {
+		// the time to run the query goes with the first page only
+		page.ExecuteTime = 0
+	}
+
+	start := time.Now()
+	var err error
+	for len(page.Rows) < n && err == nil && cursor.advance(&err) {
+		var values []any
+		if values, err = scanRow(cursor.rows, cursor.typeNames); err != nil {
+			cursor.done = true
+			err = fmt.Errorf("failed to scan row %d: %w", cursor.read+1, err)
+			break
+		}
+		cursor.pending = false
+		page.Rows = append(page.Rows, values)
+		cursor.read++
+	}
+	// look ahead, so that Done says whether this is the last page
+	if err == nil {
+		cursor.advance(&err)
+	}
+	page.FetchTime = time.Since(start)
+
+	if cursor.done && err == nil {
+		cursor.rows.Close()
+		cursor.conn.postQuery(cursor.ctx, &page)
+	}
+	page.warnTruncated(cursor.conn.Options.MaxFieldSize)
+	return newQueryResult(&page, err)
+}
+
+// advance moves on to the next row, unless it's already there, and reports
+// whether there is one. When there isn't, the cursor is done, and *err is
+// set if reading failed.
+func (cursor *Cursor) advance(err *error) bool {
+	if cursor.pending || cursor.done {
+		return cursor.pending
+	}
+	if cursor.rows.Next() {
+		cursor.pending = true
+		return true
+	}
+	cursor.done = true
+	if rowsErr := cursor.rows.Err(); rowsErr != nil {
+		*err = fmt.Errorf("failed after reading %d rows: %w", cursor.read, rowsErr)
+	}
+	return false
+}
+
+// Done reports whether every row has been read.
+func (cursor *Cursor) Done() bool {
+	return cursor.done
+}
+
+// Read returns how many rows have been read so far.
+func (cursor *Cursor) Read() int {
+	return cursor.read
+}
+
+// Close stops the query, if it has rows left, and frees the cursor.
+func (cursor *Cursor) Close() error {
+	err := cursor.rows.Close()
+	cursor.cancel()
+	return err
+}
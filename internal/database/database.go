@@ -3,18 +3,26 @@ package database
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	_ "github.com/denisenkom/go-mssqldb" // MS SQL Server
 	_ "github.com/go-sql-driver/mysql"   // MySQL
 	"github.com/godror/godror"
-	_ "github.com/godror/godror"    // Oracle
-	_ "github.com/lib/pq"           // PostgreSQL
-	_ "github.com/mattn/go-sqlite3" // SQLite
+	_ "github.com/godror/godror"        // Oracle
+	_ "github.com/lib/pq"               // PostgreSQL
+	_ "github.com/marcboeker/go-duckdb" // DuckDB
+	_ "github.com/mattn/go-sqlite3"     // SQLite
+	"github.com/snowflakedb/gosnowflake"
 
 	"sqlrepl/internal/protocol"
 )
@@ -27,6 +35,27 @@ const (
 	DriverPostgreSQL
 	DriverSQLite
 	DriverSqlServer
+	DriverSnowflake
+	DriverDuckDB
+)
+
+// DefaultQueryTimeout is how long a query may run before it's stopped, unless
+// Options.QueryTimeout says otherwise.
+const DefaultQueryTimeout = time.Second * 20
+
+// maxPingDelay caps the wait between tries to reach a database that isn't
+// answering yet, see Options.WaitForDB
+const maxPingDelay = 5 * time.Second
+
+// Connection pool limits
+const (
+	maxOpenConns = 10
+	maxIdleConns = 5
+
+	// DuckDB's connections all share one database in the process, and it
+	// already spreads each query across every core, so it needs only a
+	// couple: one for a query to run beside an open transaction
+	duckDBMaxConns = 2
 )
 
 // dbDriverNames maps driver constants to their string names
@@ -37,6 +66,8 @@ var dbDriverNames = map[int]string{
 	DriverPostgreSQL: "postgres",
 	DriverSQLite:     "sqlite",
 	DriverSqlServer:  "sqlserver",
+	DriverSnowflake:  "snowflake",
+	DriverDuckDB:     "duckdb",
 }
 
 // dbDriverTypes maps lowercase driver names to their driver constants
@@ -46,6 +77,8 @@ var dbDriverTypes = map[string]int{
 	"postgres":  DriverPostgreSQL,
 	"sqlite":    DriverSQLite,
 	"sqlserver": DriverSqlServer,
+	"snowflake": DriverSnowflake,
+	"duckdb":    DriverDuckDB,
 }
 
 // ValidateDBType validates the database type and returns the corresponding driver constant.
@@ -58,6 +91,16 @@ func ValidateDBType(dbType string) (int, error) {
 	return driver, nil
 }
 
+// SupportedDBTypes returns the database types accepted by Connect, sorted.
+func SupportedDBTypes() []string {
+	dbTypes := make([]string, 0, len(dbDriverTypes))
+	for dbType := range dbDriverTypes {
+		dbTypes = append(dbTypes, dbType)
+	}
+	sort.Strings(dbTypes)
+	return dbTypes
+}
+
 // DBTypeString returns the string representation of a database type.
 func DBTypeString(dbType int) string {
 	name, ok := dbDriverNames[dbType]
@@ -67,10 +110,166 @@ func DBTypeString(dbType int) string {
 	return name
 }
 
+// QuoteIdentifier quotes name as an identifier for the given database type,
+// doubling any quote characters inside it.
+func QuoteIdentifier(dbType int, name string) string {
+	switch dbType {
+	case DriverMySQL:
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	case DriverSqlServer:
+		return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteName quotes each part of a table name like schema.table that isn't a
+// plain identifier. Plain ones are left as they are, so that they fold to the
+// database's case as they would written in a query.
+func QuoteName(dbType int, name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if !plainIdentifier.MatchString(part) {
+			parts[i] = QuoteIdentifier(dbType, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// plainIdentifier matches the names that need no quoting in any database.
+var plainIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TruncateStatement returns the statement that removes every row from table,
+// a name as given to QuoteName: TRUNCATE TABLE, or DELETE for sqlite, which
+// has no TRUNCATE.
+func TruncateStatement(dbType int, table string) string {
+	if dbType == DriverSQLite {
+		return "DELETE FROM " + QuoteName(dbType, table)
+	}
+	return "TRUNCATE TABLE " + QuoteName(dbType, table)
+}
+
+// QuoteLiteral quotes value as a string literal for the given database type.
+func QuoteLiteral(dbType int, value string) string {
+	value = strings.ReplaceAll(value, "'", "''")
+	switch dbType {
+	case DriverMySQL:
+		// mysql treats backslashes in literals as escapes
+		return "'" + strings.ReplaceAll(value, `\`, `\\`) + "'"
+	case DriverSqlServer:
+		return "N'" + value + "'"
+	}
+	return "'" + value + "'"
+}
+
+// Options holds the settings that control how a Connection runs queries.
+type Options struct {
+	// FetchSize is the number of rows fetched from the database per round
+	// trip, for drivers that support it. Zero leaves the driver default.
+	FetchSize int
+
+	// DriverOptions are merged into the connection string in the form the
+	// driver expects, e.g. sslmode=require for postgres
+	DriverOptions map[string]string
+
+	// ReadOnly makes the session read-only where the driver supports it,
+	// and rejects statements that look like writes for every driver.
+	// Postgres, mysql, sqlite and duckdb sessions are read-only. Oracle has
+	// no read-only sessions, so its queries and transactions are read-only
+	// transactions instead. SQL server only asks to be sent to a read-only
+	// replica, which a server without one ignores, and snowflake has
+	// nothing, so those two are only guarded by the check for writes.
+	ReadOnly bool
+
+	// Schema is the schema (the database, for mysql) that every session in
+	// the pool starts in; empty leaves the connection string's default
+	Schema string
+
+	// MaxFieldSize is the length at which the driver is known to cut off
+	// long text and binary values; values that reach it are reported as
+	// possibly truncated. Zero turns the check off.
+	MaxFieldSize int
+
+	// MaxOutputSize caps how many bytes of printed output, like oracle's
+	// DBMS_OUTPUT, are kept with a result; the rest is dropped with a note.
+	// Zero keeps all of it.
+	MaxOutputSize int
+
+	// NoDbmsOutput skips enabling and reading oracle's DBMS_OUTPUT, which
+	// saves a round trip after every query
+	NoDbmsOutput bool
+
+	// Pools, if set, is shared by connections that should share a pool with
+	// any others opened with the same parameters, see Pools
+	Pools *Pools
+
+	// ClientEncoding is the character set text is exchanged with the
+	// database in, set for every session in the pool; empty leaves the
+	// driver's default
+	ClientEncoding string
+
+	// QueryTimeout is how long a query may run before it's stopped. Zero
+	// uses DefaultQueryTimeout.
+	QueryTimeout time.Duration
+
+	// WaitForDB is how long Connect keeps trying to reach a database that
+	// doesn't answer yet, such as one still starting up. Zero tries once.
+	WaitForDB time.Duration
+
+	// NoPing opens the connection without checking that the database can
+	// be reached, leaving any problem to be reported by the first query
+	NoPing bool
+
+	// OnQuery, if set, is called with every statement sent to the database
+	// on the user's behalf, exactly as it's sent, such as to write it to a
+	// query log. Queries the connection makes for itself, like finding the
+	// current schema, aren't passed to it.
+	OnQuery func(query string)
+}
+
 type Connection struct {
+	Options Options
+
 	db      *sql.DB
 	dbType  int
 	context context.Context
+
+	// tx is the open transaction, if any; queries run inside it
+	tx *sql.Tx
+
+	// manualCommit means autocommit is off: queries start a transaction
+	// when none is open, and it is only ended by Commit or Rollback
+	manualCommit bool
+
+	// params are the dbType and connection string given to Connect, kept
+	// so that Reconnect can open the connection again
+	params [2]string
+
+	// lastQuery is the last query sent to the database, as changed by
+	// preQuery
+	lastQuery string
+
+	// dsn is the connection string as it was opened, with the options
+	// applied, for opening connections outside the pool
+	dsn string
+
+	// notices gathers postgres notices for the results of the queries that
+	// raised them, nil when the pool is shared or it's another database
+	notices *notices
+}
+
+// DBType returns the driver constant of the open connection.
+func (conn *Connection) DBType() int {
+	return conn.dbType
+}
+
+// QuoteIdentifier quotes name as an identifier for the connection's database.
+func (conn *Connection) QuoteIdentifier(name string) string {
+	return QuoteIdentifier(conn.dbType, name)
+}
+
+// QuoteLiteral quotes value as a string literal for the connection's database.
+func (conn *Connection) QuoteLiteral(value string) string {
+	return QuoteLiteral(conn.dbType, value)
 }
 
 // Connect opens the database connection.
@@ -82,104 +281,877 @@ func (conn *Connection) Connect(dbType string, dbConnString string) (err error)
 	if err != nil {
 		return
 	}
+	conn.params = [2]string{dbType, dbConnString}
 
-	db, err = sql.Open(dbDriverNames[driver], dbConnString)
+	driverOptions := conn.Options.DriverOptions
+	if conn.Options.ReadOnly {
+		driverOptions = withReadOnly(driver, driverOptions)
+	}
+	if conn.Options.Schema != "" {
+		dbConnString, driverOptions, err = withSchema(driver, dbConnString, driverOptions, conn.Options.Schema)
+		if err != nil {
+			return
+		}
+	}
+	if conn.Options.ClientEncoding != "" {
+		driverOptions, err = withClientEncoding(driver, driverOptions, conn.Options.ClientEncoding)
+		if err != nil {
+			return
+		}
+	}
+	dbConnString, err = applyDriverOptions(driver, dbConnString, driverOptions)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return
 	}
 
-	// Set connection pooling parameters
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
+	switch driver {
+	case DriverDuckDB:
+		dbConnString = duckDBDSN(dbConnString)
+	case DriverSnowflake:
+		// the warehouse and role are set for the session from the DSN
+		// (e.g. ?warehouse=wh&role=analyst); parse it up front so a bad
+		// DSN is reported clearly rather than as a failed ping
+		config, err := gosnowflake.ParseDSN(dbConnString)
+		if err != nil {
+			return fmt.Errorf("invalid snowflake connection string: %w", err)
+		}
+		log.Printf("Using snowflake warehouse %q, role %q", config.Warehouse, config.Role)
+	}
 
-	if err = db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %w", err)
+	conn.notices = nil
+	if conn.Options.Pools != nil {
+		// the connections sharing a pool can't tell whose query a notice
+		// came from, so they go without
+		db, err = conn.Options.Pools.acquire(poolKey{driver, dbConnString}, func() (*sql.DB, error) {
+			return openDB(driver, dbConnString, nil, conn.Options)
+		})
+	} else {
+		if driver == DriverPostgreSQL {
+			conn.notices = &notices{limit: conn.Options.MaxOutputSize}
+		}
+		db, err = openDB(driver, dbConnString, conn.notices, conn.Options)
+	}
+	if err != nil {
+		return
 	}
 
-	log.Println("Successfully connected to the database")
+	if conn.Options.NoPing {
+		log.Println("Opened the database without checking that it can be reached")
+	} else {
+		log.Println("Successfully connected to the database")
+	}
 
 	conn.db = db
 	conn.dbType = driver
+	conn.dsn = dbConnString
 	conn.context = context.TODO()
 
 	switch driver {
 	case DriverOracle:
-		db.Exec("SET SQLBLANKLINES ON")
-		godror.EnableDbmsOutput(conn.context, conn.db)
+		if _, err := db.Exec("SET SQLBLANKLINES ON"); err != nil {
+			log.Printf("Unable to set SQLBLANKLINES: %v", err)
+		}
+		if !conn.Options.NoDbmsOutput {
+			if err := godror.EnableDbmsOutput(conn.context, conn.db); err != nil {
+				log.Printf("Unable to enable DBMS_OUTPUT: %v", err)
+			}
+		}
 	}
 
 	return
 }
 
+// openDB opens a pool of connections to the database and, unless
+// options.NoPing, checks that it can be reached, trying for up to
+// options.WaitForDB. Postgres notices are gathered in notices, unless it's
+// nil.
+func openDB(driver int, dsn string, notices *notices, options Options) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+	if driver == DriverPostgreSQL && notices != nil {
+		db, err = openPostgres(dsn, notices.add)
+	} else {
+		db, err = sql.Open(dbDriverNames[driver], dsn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Set connection pooling parameters
+	if driver == DriverDuckDB {
+		db.SetMaxOpenConns(duckDBMaxConns)
+		db.SetMaxIdleConns(duckDBMaxConns)
+	} else {
+		db.SetMaxOpenConns(maxOpenConns)
+		db.SetMaxIdleConns(maxIdleConns)
+	}
+
+	if options.NoPing {
+		return db, nil
+	}
+	if err = pingDB(db, options.WaitForDB); err != nil {
+		db.Close()
+		if isTooManyConnections(err) {
+			return nil, fmt.Errorf("%w (%v); the server is at capacity, so close idle sessions elsewhere "+
+				"or reduce the number of clients (each opens up to %d connections)",
+				ErrTooManyConnections, err, maxOpenConns)
+		}
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return db, nil
+}
+
+// pingDB checks that the database can be reached. Until wait has passed it
+// keeps trying, waiting longer between each try, so that a database that is
+// still starting up has time to.
+func pingDB(db *sql.DB, wait time.Duration) error {
+	err := db.Ping()
+	if err == nil || wait <= 0 {
+		return err
+	}
+
+	deadline := time.Now().Add(wait)
+	delay := 250 * time.Millisecond
+	for err != nil && time.Now().Before(deadline) {
+		log.Printf("Waiting for the database: %v", err)
+		time.Sleep(min(delay, time.Until(deadline)))
+		delay = min(delay*2, maxPingDelay)
+		err = db.Ping()
+	}
+	return err
+}
+
 // ExecuteQuery executes a SQL query.
 func (conn *Connection) ExecuteQuery(query string) *protocol.QueryResult {
-	result := &protocol.QueryResult{}
+	return conn.ExecuteQueryContext(conn.context, query)
+}
+
+// ExecuteQueryWithTimeout executes a SQL query that is stopped after timeout
+// in place of the usual limit, for just this query.
+func (conn *Connection) ExecuteQueryWithTimeout(query string, timeout time.Duration) *protocol.QueryResult {
+	return conn.executeQuery(conn.context, query, timeout)
+}
 
-	// TODO: make this timeout duration configurable
-	context, cancelFunc := context.WithTimeout(conn.context, time.Second*20)
+// ExecuteQueryContext executes a SQL query that is stopped if ctx is
+// cancelled.
+func (conn *Connection) ExecuteQueryContext(ctx context.Context, query string) *protocol.QueryResult {
+	return conn.executeQuery(ctx, query, conn.queryTimeout())
+}
+
+func (conn *Connection) executeQuery(ctx context.Context, query string, timeout time.Duration) *protocol.QueryResult {
+	result, err := conn.executeQueryTyped(ctx, query, timeout)
+	start := time.Now()
+	protoResult := newQueryResult(result, err)
+	// converting the values to strings is part of fetching them
+	protoResult.FetchMicros += time.Since(start).Microseconds()
+	if err != nil {
+		protoResult.ErrorPosition = int32(errorPosition(err, conn.lastQuery))
+	}
+	return protoResult
+}
+
+// ExecuteQueryTyped executes a SQL query, keeping the values as scanned from
+// the driver rather than converting them to strings. If reading the rows fails
+// part way through, the rows gathered so far are returned along with the error.
+func (conn *Connection) ExecuteQueryTyped(query string) (*TypedResult, error) {
+	return conn.executeQueryTyped(conn.context, query, conn.queryTimeout())
+}
+
+func (conn *Connection) executeQueryTyped(ctx context.Context, query string, timeout time.Duration) (*TypedResult, error) {
+	context, cancelFunc := context.WithTimeout(ctx, timeout)
 	defer cancelFunc()
 
-	conn.preQuery(&query)
-	rows, err := conn.db.QueryContext(context, query)
+	// mysql keeps the warnings of the last statement on the session, so
+	// hold on to one connection to run the query and read them
+	var session *sql.Conn
+	if conn.dbType == DriverMySQL && conn.tx == nil && !conn.manualCommit {
+		var err error
+		if session, err = conn.db.Conn(context); err != nil {
+			return nil, err
+		}
+		defer session.Close()
+	}
+
+	// a query outside a transaction on oracle runs in a read-only one of its
+	// own, see readOnlyTransactions
+	var readOnlyTx *sql.Tx
+	if conn.readOnlyTransactions() && conn.tx == nil && !conn.manualCommit {
+		var err error
+		if readOnlyTx, err = conn.db.BeginTx(context, &sql.TxOptions{ReadOnly: true}); err != nil {
+			return nil, err
+		}
+		defer readOnlyTx.Rollback()
+	}
+
+	var on queryable
+	switch {
+	case session != nil:
+		on = session
+	case readOnlyTx != nil:
+		on = readOnlyTx
+	}
+
+	start := time.Now()
+	rows, err := conn.queryOn(context, on, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	executed := time.Now()
+
+	result, err := readRows(rows)
+	if result != nil {
+		result.ExecuteTime, result.FetchTime = executed.Sub(start), time.Since(executed)
+	}
+	if err != nil {
+		// what the query printed before it failed goes with the rows it
+		// got through, rather than being left for the next query
+		if result != nil {
+			rows.Close()
+			conn.postQuery(context, result)
+		}
+		return result, err
+	}
+	rows.Close()
+
+	conn.postQuery(context, result)
+	result.warnTruncated(conn.Options.MaxFieldSize)
+	conn.readWarnings(context, session, result)
+	return result, nil
+}
+
+// readWarnings adds the warnings the database raised running the query to
+// its result, for the drivers that report them.
+func (conn *Connection) readWarnings(ctx context.Context, session *sql.Conn, result *TypedResult) {
+	switch conn.dbType {
+	case DriverMySQL:
+		var warnings []string
+		var err error
+		if session != nil {
+			warnings, err = mysqlWarnings(ctx, session)
+		} else {
+			warnings, err = mysqlWarnings(ctx, conn.querier())
+		}
+		if err != nil {
+			log.Printf("Unable to read warnings: %v", err)
+		}
+		result.Warnings = append(result.Warnings, warnings...)
+	}
+}
+
+// StreamQuery executes a SQL query and passes each row to onRow as it's read,
+// rather than gathering the whole result in memory. onColumns is called with
+// the column names before any rows. The message the query produced, if any,
+// is returned once all the rows have been read. The query is stopped if ctx
+// is cancelled.
+func (conn *Connection) StreamQuery(ctx context.Context, query string, onColumns func(columns []string) error, onRow func(values []any) error) (string, error) {
+	context, cancelFunc := context.WithTimeout(ctx, conn.queryTimeout())
+	defer cancelFunc()
+
+	rows, err := conn.query(context, query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	if err = onColumns(columns); err != nil {
+		return "", err
+	}
+	if _, err = eachRow(rows, len(columns), onRow); err != nil {
+		return "", err
+	}
+
+	result := &TypedResult{}
+	conn.postQuery(context, result)
+	return result.Message, nil
+}
+
+// errStopRows ends eachRow early once the row wanted has been handled.
+var errStopRows = errors.New("stop reading rows")
+
+// CopyValue runs query again and copies the value in the given row and column,
+// both counted from 0, to w without converting it to a string first, so that
+// binary values like a BLOB or bytea come out intact. Oracle LOBs are streamed
+// from the database rather than read into memory. It returns the number of
+// bytes written.
+func (conn *Connection) CopyValue(w io.Writer, query string, row, col int) (int64, error) {
+	if IsWrite(query) {
+		return 0, fmt.Errorf("only a query that doesn't change data can be run again")
+	}
+
+	context, cancelFunc := context.WithTimeout(conn.context, conn.queryTimeout())
+	defer cancelFunc()
+
+	var args []any
+	if conn.dbType == DriverOracle {
+		args = append(args, godror.LobAsReader())
+	}
+	rows, err := conn.query(context, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if col >= len(columns) {
+		return 0, fmt.Errorf("the query now returns only %d columns", len(columns))
+	}
+
+	// a LOB has to be read before moving on to the next row, so the value
+	// is copied as soon as its row is scanned
+	var written int64
+	current := 0
+	_, err = eachRow(rows, len(columns), func(values []any) error {
+		if current < row {
+			current++
+			return nil
+		}
+		written, err = copyValue(w, values[col])
+		if err != nil {
+			return err
+		}
+		return errStopRows
+	})
+	if err == errStopRows {
+		return written, nil
+	}
+	if err != nil {
+		return written, err
+	}
+	return 0, fmt.Errorf("the query now returns only %d rows", current)
+}
+
+// copyValue writes a scanned value to w as it is: bytes and strings
+// unchanged, readers like a LOB copied through, anything else formatted as it
+// would be printed.
+func copyValue(w io.Writer, value any) (int64, error) {
+	switch value := value.(type) {
+	case nil:
+		return 0, fmt.Errorf("the value is NULL")
+	case []byte:
+		n, err := w.Write(value)
+		return int64(n), err
+	case io.Reader:
+		return io.Copy(w, value)
+	default:
+		n, err := io.WriteString(w, FormatValue(value))
+		return int64(n), err
+	}
+}
+
+// QueryInto executes a SQL query with the given bind arguments and scans its
+// rows into dest, which must point to a slice of structs, or of pointers to
+// structs. Each column is stored in the field whose `db` tag names it, or
+// failing that the field whose name matches it ignoring case; columns with no
+// matching field are skipped. A NULL sets a pointer field to nil and any
+// other field to its zero value.
+func (conn *Connection) QueryInto(dest any, query string, args ...any) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Pointer || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("QueryInto needs a pointer to a slice, not %T", dest)
+	}
+	slice = slice.Elem()
+	elemType := slice.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Pointer {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return fmt.Errorf("QueryInto needs a slice of structs, not %s", slice.Type())
+	}
+
+	context, cancelFunc := context.WithTimeout(conn.context, conn.queryTimeout())
+	defer cancelFunc()
+
+	rows, err := conn.query(context, query, args...)
 	if err != nil {
-		result.Error = err.Error()
-		return result
+		return err
 	}
 	defer rows.Close()
 
 	columns, err := rows.Columns()
 	if err != nil {
-		result.Error = err.Error()
-		return result
+		return err
+	}
+	fields := make([][]int, len(columns))
+	for i, col := range columns {
+		fields[i] = structField(structType, col)
 	}
-	result.Columns = columns
 
-	for rows.Next() {
-		values := make([]any, len(columns))
+	for n := 1; rows.Next(); n++ {
+		record := reflect.New(structType).Elem()
+
+		// each column is scanned into a pointer to its field's type, so
+		// that the driver can report a NULL as nil
 		scanArgs := make([]any, len(columns))
-		for i := range values {
-			scanArgs[i] = &values[i]
+		for i, index := range fields {
+			if index == nil {
+				scanArgs[i] = new(any)
+				continue
+			}
+			scanArgs[i] = reflect.New(reflect.PointerTo(record.FieldByIndex(index).Type())).Interface()
+		}
+		if err = rows.Scan(scanArgs...); err != nil {
+			return fmt.Errorf("failed to scan row %d: %w", n, err)
 		}
 
-		err = rows.Scan(scanArgs...)
-		if err != nil {
-			result.Error = err.Error()
-			return result
+		for i, index := range fields {
+			if index == nil {
+				continue
+			}
+			if value := reflect.ValueOf(scanArgs[i]).Elem(); !value.IsNil() {
+				record.FieldByIndex(index).Set(value.Elem())
+			}
+		}
+		if elemType.Kind() == reflect.Pointer {
+			record = record.Addr()
 		}
+		slice.Set(reflect.Append(slice, record))
+	}
+	return rows.Err()
+}
+
+// structField returns the index of the exported field of structType that
+// column is stored in, or nil if there's none. A field tagged `db:"-"` is
+// never used.
+func structField(structType reflect.Type, column string) []int {
+	var byName []int
+	for _, field := range reflect.VisibleFields(structType) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("db")
+		if tag == column {
+			return field.Index
+		}
+		if tag == "" && byName == nil && strings.EqualFold(field.Name, column) {
+			byName = field.Index
+		}
+	}
+	return byName
+}
+
+// query checks and runs a query, starting a transaction first if autocommit
+// is off.
+func (conn *Connection) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return conn.queryOn(ctx, nil, query, args...)
+}
+
+// queryOn is query run on session, a connection or a transaction held outside
+// of the open transaction, or as query does when session is nil.
+func (conn *Connection) queryOn(ctx context.Context, session queryable, query string, args ...any) (*sql.Rows, error) {
+	if conn.Options.ReadOnly && IsWrite(query) {
+		return nil, fmt.Errorf("statement rejected: the connection is read-only")
+	}
+
+	if session == nil && conn.manualCommit && conn.tx == nil {
+		if err := conn.Begin(); err != nil {
+			return nil, err
+		}
+	}
+
+	if conn.notices != nil {
+		// drop any left over from a query that failed
+		conn.notices.take()
+	}
+
+	conn.preQuery(&query)
+	conn.sent(query)
+	args = append(args, conn.queryOptions()...)
+	if session != nil {
+		return session.QueryContext(ctx, query, args...)
+	}
+	return conn.querier().QueryContext(ctx, query, args...)
+}
+
+// sent records that query is being sent to the database, for LastQuery and
+// Options.OnQuery.
+func (conn *Connection) sent(query string) {
+	conn.lastQuery = query
+	if conn.Options.OnQuery != nil {
+		conn.Options.OnQuery(query)
+	}
+}
+
+// LastQuery returns the last query sent to the database, exactly as it was
+// sent.
+func (conn *Connection) LastQuery() string {
+	return conn.lastQuery
+}
+
+// CallProcedure runs a PL/SQL block whose only bind placeholder is a
+// SYS_REFCURSOR OUT parameter, and returns the rows read from that cursor.
+// e.g. `BEGIN my_pkg.get_orders(42, :cur); END;`
+func (conn *Connection) CallProcedure(call string) *protocol.QueryResult {
+	return newQueryResult(conn.callProcedure(call))
+}
+
+func (conn *Connection) callProcedure(call string) (*TypedResult, error) {
+	if conn.dbType != DriverOracle {
+		return nil, fmt.Errorf("ref cursor calls are not supported for %s", DBTypeString(conn.dbType))
+	}
+	// a procedure can write whatever it's called with, so none are called
+	// on a read-only connection
+	if conn.Options.ReadOnly {
+		return nil, fmt.Errorf("call rejected: the connection is read-only")
+	}
+
+	context, cancelFunc := context.WithTimeout(conn.context, conn.queryTimeout())
+	defer cancelFunc()
+
+	// the cursor has to be read on the same session that opened it, so
+	// don't let the pool hand us a different connection part way through
+	session, err := conn.db.Conn(context)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	// a PL/SQL block must end with a semicolon
+	call = strings.TrimSpace(call)
+	if !strings.HasSuffix(call, ";") {
+		call += ";"
+	}
+	conn.sent(call)
+
+	var cursor driver.Rows
+	if _, err = session.ExecContext(context, call, sql.Out{Dest: &cursor}); err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	rows, err := godror.WrapRows(context, session, cursor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		rowValues := make([]string, len(columns))
-		for i, val := range values {
-			if val == nil {
-				rowValues[i] = "<nil>"
-			} else {
-				rowValues[i] = fmt.Sprintf("%v", val)
+	result, err := readRows(rows)
+	if err != nil {
+		return result, err
+	}
+
+	conn.postQuery(context, result)
+	result.warnTruncated(conn.Options.MaxFieldSize)
+	return result, nil
+}
+
+// QueryColumns describes the columns query would return, without fetching any
+// of its rows. The result has one row per column.
+func (conn *Connection) QueryColumns(query string) *protocol.QueryResult {
+	// wrapping the query in a filter that's never true works for every
+	// supported dialect, where LIMIT 0 or TOP 0 would not
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	result, err := conn.ExecuteQueryTyped(fmt.Sprintf("SELECT * FROM (%s) q WHERE 1 = 0", query))
+	if err != nil {
+		return &protocol.QueryResult{Error: err.Error()}
+	}
+
+	description := &protocol.QueryResult{Columns: []string{"column", "type", "nullable", "length", "precision"}}
+	for i, col := range result.Columns {
+		values := []string{col, "", "", "", ""}
+		if i < len(result.ColumnTypes) {
+			columnType := result.ColumnTypes[i]
+			values[1] = columnType.DatabaseTypeName()
+			if nullable, ok := columnType.Nullable(); ok {
+				values[2] = strconv.FormatBool(nullable)
+			}
+			if length, ok := columnType.Length(); ok {
+				values[3] = strconv.FormatInt(length, 10)
+			}
+			if precision, scale, ok := columnType.DecimalSize(); ok {
+				values[4] = fmt.Sprintf("%d,%d", precision, scale)
 			}
 		}
+		description.Rows = append(description.Rows, &protocol.Row{Values: values})
+	}
+	return description
+}
+
+// Indexes returns the indexes on table, one row each with the index's name,
+// its columns in order, whether it's unique, and its type, such as btree.
+// The table can be qualified with its schema; otherwise it's looked for in
+// the current one.
+func (conn *Connection) Indexes(table string) *protocol.QueryResult {
+	schema, name, qualified := strings.Cut(table, ".")
+	if !qualified {
+		name = schema
+	}
+	literal := conn.QuoteLiteral
+
+	var query string
+	switch conn.dbType {
+	case DriverPostgreSQL:
+		// pg_indexes only has each index's definition, so the columns
+		// come from the catalog, which regclass finds the table in
+		query = `SELECT c.relname AS "index",
+			(SELECT string_agg(pg_get_indexdef(x.indexrelid, k, true), ', ' ORDER BY k)
+				FROM generate_series(1, x.indnkeyatts) k) AS columns,
+			x.indisunique AS "unique", am.amname AS type
+			FROM pg_index x
+			JOIN pg_class c ON c.oid = x.indexrelid
+			JOIN pg_am am ON am.oid = c.relam
+			WHERE x.indrelid = ` + literal(QuoteName(conn.dbType, table)) + `::regclass
+			ORDER BY c.relname`
+
+	case DriverMySQL:
+		// SHOW INDEX lists a row per column, so they're gathered from the
+		// statistics it reads instead
+		schemaFilter := "DATABASE()"
+		if qualified {
+			schemaFilter = literal(schema)
+		}
+		query = "SELECT index_name AS `index`," +
+			" GROUP_CONCAT(COALESCE(column_name, '(expression)') ORDER BY seq_in_index SEPARATOR ', ') AS columns," +
+			" CASE WHEN non_unique = 0 THEN 'true' ELSE 'false' END AS `unique`, index_type AS type" +
+			" FROM information_schema.statistics" +
+			" WHERE table_schema = " + schemaFilter + " AND table_name = " + literal(name) +
+			" GROUP BY index_name, non_unique, index_type ORDER BY index_name"
+
+	case DriverOracle:
+		views, owner := "user_indexes i JOIN user_ind_columns c ON c.index_name = i.index_name", ""
+		if qualified {
+			views = "all_indexes i JOIN all_ind_columns c ON c.index_owner = i.owner AND c.index_name = i.index_name"
+			owner = " AND i.table_owner = " + literal(strings.ToUpper(schema))
+		}
+		query = `SELECT i.index_name AS "index",
+			LISTAGG(c.column_name, ', ') WITHIN GROUP (ORDER BY c.column_position) AS columns,
+			CASE i.uniqueness WHEN 'UNIQUE' THEN 'true' ELSE 'false' END AS "unique", i.index_type AS type
+			FROM ` + views + `
+			WHERE i.table_name = ` + literal(strings.ToUpper(name)) + owner + `
+			GROUP BY i.index_name, i.uniqueness, i.index_type
+			ORDER BY i.index_name`
+
+	case DriverSqlServer:
+		query = `SELECT i.name AS [index],
+			STRING_AGG(c.name, ', ') WITHIN GROUP (ORDER BY ic.key_ordinal) AS columns,
+			CASE WHEN i.is_unique = 1 THEN 'true' ELSE 'false' END AS [unique], LOWER(i.type_desc) AS type
+			FROM sys.indexes i
+			JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id AND ic.is_included_column = 0
+			JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+			WHERE i.object_id = OBJECT_ID(` + literal(table) + `)
+			GROUP BY i.name, i.is_unique, i.type_desc
+			ORDER BY i.name`
+
+	case DriverSQLite:
+		// PRAGMA index_list has no columns, which index_info has for each
+		listArgs := literal(name)
+		if qualified {
+			listArgs += ", " + literal(schema)
+		}
+		query = `SELECT il.name AS "index",
+			(SELECT group_concat(column, ', ') FROM
+				(SELECT COALESCE(ii.name, '(expression)') AS column FROM pragma_index_info(il.name) ii ORDER BY ii.seqno)) AS columns,
+			CASE il."unique" WHEN 1 THEN 'true' ELSE 'false' END AS "unique", 'btree' AS type
+			FROM pragma_index_list(` + listArgs + `) il
+			ORDER BY il.name`
 
-		protoRow := &protocol.Row{
-			Values: rowValues,
+	case DriverDuckDB:
+		schemaFilter := "current_schema()"
+		if qualified {
+			schemaFilter = literal(schema)
 		}
-		result.Rows = append(result.Rows, protoRow)
+		query = `SELECT index_name AS "index", array_to_string(expressions, ', ') AS columns,
+			is_unique AS "unique", 'art' AS type
+			FROM duckdb_indexes()
+			WHERE schema_name = ` + schemaFilter + ` AND table_name = ` + literal(name) + `
+			ORDER BY index_name`
+
+	default:
+		return &protocol.QueryResult{Error: fmt.Sprintf("listing indexes is not supported for %s", DBTypeString(conn.dbType))}
+	}
+	return conn.ExecuteQuery(query)
+}
+
+// Explain returns the query plan the database would use for query.
+func (conn *Connection) Explain(query string) *protocol.QueryResult {
+	switch conn.dbType {
+	case DriverPostgreSQL, DriverMySQL, DriverSQLite, DriverDuckDB:
+		return conn.ExecuteQuery("EXPLAIN " + query)
+	}
+	return &protocol.QueryResult{Error: fmt.Sprintf("EXPLAIN is not supported for %s", DBTypeString(conn.dbType))}
+}
+
+// ExplainJSON returns the query plan the database would use for query as a
+// JSON document, for the databases that can describe plans that way.
+func (conn *Connection) ExplainJSON(query string) (string, error) {
+	switch conn.dbType {
+	case DriverPostgreSQL:
+		result, err := conn.ExecuteQueryTyped("EXPLAIN (FORMAT JSON) " + query)
+		if err != nil {
+			return "", err
+		}
+		if len(result.Rows) != 1 || len(result.Rows[0]) != 1 {
+			return "", fmt.Errorf("unexpected EXPLAIN output: %d rows", len(result.Rows))
+		}
+		switch plan := result.Rows[0][0].(type) {
+		case string:
+			return plan, nil
+		case []byte:
+			return string(plan), nil
+		}
+		return FormatValue(result.Rows[0][0]), nil
+	}
+	return "", fmt.Errorf("JSON plans are not supported for %s, only postgres", DBTypeString(conn.dbType))
+}
+
+// queryable is what a query can be run on: the pool, a connection held from
+// it, or a transaction.
+type queryable interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// querier returns what queries should run against: the open transaction if
+// there is one, otherwise the connection pool.
+func (conn *Connection) querier() queryable {
+	if conn.tx != nil {
+		return conn.tx
+	}
+	return conn.db
+}
+
+// Begin starts a transaction that subsequent queries run inside.
+func (conn *Connection) Begin() error {
+	if conn.tx != nil {
+		return fmt.Errorf("a transaction is already open")
+	}
+	var options *sql.TxOptions
+	if conn.readOnlyTransactions() {
+		options = &sql.TxOptions{ReadOnly: true}
+	}
+	tx, err := conn.db.BeginTx(conn.context, options)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	conn.tx = tx
+	return nil
+}
+
+// readOnlyTransactions reports whether queries are kept from writing by
+// running them in read-only transactions, which is how a read-only oracle
+// connection works, since oracle has no read-only sessions.
+func (conn *Connection) readOnlyTransactions() bool {
+	return conn.Options.ReadOnly && conn.dbType == DriverOracle
+}
+
+// Commit commits the open transaction.
+func (conn *Connection) Commit() error {
+	if conn.tx == nil {
+		return fmt.Errorf("no transaction is open")
+	}
+	err := conn.tx.Commit()
+	conn.tx = nil
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Rollback rolls back the open transaction.
+func (conn *Connection) Rollback() error {
+	if conn.tx == nil {
+		return fmt.Errorf("no transaction is open")
+	}
+	err := conn.tx.Rollback()
+	conn.tx = nil
+	if err != nil {
+		return fmt.Errorf("failed to roll back transaction: %w", err)
 	}
+	return nil
+}
 
-	if err = rows.Err(); err != nil {
-		result.Error = err.Error()
-		return result
+// InTransaction reports whether a transaction is open.
+func (conn *Connection) InTransaction() bool {
+	return conn.tx != nil
+}
+
+// SetAutocommit turns autocommit on or off. With it off, each query joins the
+// open transaction, starting one if needed. It can't be turned back on while
+// a transaction is open.
+func (conn *Connection) SetAutocommit(on bool) error {
+	if on && conn.tx != nil {
+		return fmt.Errorf("commit or roll back the open transaction first")
 	}
+	conn.manualCommit = !on
+	return nil
+}
+
+// Autocommit reports whether each query is committed on its own, rather
+// than joining a transaction that's only ended by Commit or Rollback.
+func (conn *Connection) Autocommit() bool {
+	return !conn.manualCommit
+}
 
-	conn.postQuery(result)
-	return result
+// Sibling returns a connection to the same database that shares conn's pool
+// but none of its state, such as its transaction or last query, so that it
+// can run queries at the same time as conn. It runs with autocommit on and
+// doesn't gather postgres notices. It needn't be closed, and stops working
+// once conn is closed or reconnected.
+func (conn *Connection) Sibling() *Connection {
+	return &Connection{
+		Options: conn.Options,
+		db:      conn.db,
+		dbType:  conn.dbType,
+		context: conn.context,
+		params:  conn.params,
+		dsn:     conn.dsn,
+	}
+}
+
+// queryTimeout returns how long a query may run before it's stopped.
+func (conn *Connection) queryTimeout() time.Duration {
+	if conn.Options.QueryTimeout > 0 {
+		return conn.Options.QueryTimeout
+	}
+	return DefaultQueryTimeout
+}
+
+// Ping checks that the database is still reachable.
+func (conn *Connection) Ping(ctx context.Context) error {
+	if err := conn.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
+
+// Reconnect closes the connection and opens it again with the same
+// parameters. Any open transaction is lost.
+func (conn *Connection) Reconnect() error {
+	if conn.db != nil {
+		conn.closeDB()
+	}
+	return conn.Connect(conn.params[0], conn.params[1])
 }
 
 // Close closes the database connection.
 func (conn *Connection) Close() error {
-	if err := conn.db.Close(); err != nil {
+	if err := conn.closeDB(); err != nil {
 		return fmt.Errorf("failed to close database: %w", err)
 	}
 	log.Println("Successfully closed the database connection")
 	return nil
 }
 
+// closeDB rolls back any open transaction, which would otherwise hold on to a
+// connection of a shared pool, and closes the pool or gives up its share of it.
+func (conn *Connection) closeDB() error {
+	if conn.tx != nil {
+		conn.tx.Rollback()
+		conn.tx = nil
+	}
+	if conn.Options.Pools != nil {
+		return conn.Options.Pools.release(poolKey{conn.dbType, conn.dsn})
+	}
+	return conn.db.Close()
+}
+
 // some drivers need tweaks to the query, like ensuring that there's
 // a semicolon at the end and such. This function houses that logic.
 // Directly modifies `query`
@@ -198,17 +1170,69 @@ func (conn *Connection) preQuery(query *string) {
 	}
 }
 
+// some drivers accept per-statement options passed alongside the query
+// arguments, such as how many rows to fetch at a time.
+func (conn *Connection) queryOptions() []any {
+	var options []any
+	switch conn.dbType {
+	case DriverOracle:
+		if conn.Options.FetchSize > 0 {
+			options = append(options, godror.FetchArraySize(conn.Options.FetchSize))
+		}
+	}
+	return options
+}
+
 // some drivers need to do some extra steps after a query, such as processing
-// output from print statements
-func (conn *Connection) postQuery(result *protocol.QueryResult) {
+// output from print statements. ctx is the query's, so that a query that
+// prints without end still stops at its deadline.
+func (conn *Connection) postQuery(ctx context.Context, result *TypedResult) {
 	switch conn.dbType {
 	case DriverOracle:
+		if conn.Options.NoDbmsOutput {
+			return
+		}
 		var builder strings.Builder
-		var writer io.Writer = &builder
-		err := godror.ReadDbmsOutput(conn.context, writer, conn.db)
+		writer := &cappedWriter{w: &builder, limit: conn.Options.MaxOutputSize}
+		err := godror.ReadDbmsOutput(ctx, writer, conn.db)
+		result.Message = builder.String()
+		if writer.truncated {
+			result.Message += fmt.Sprintf("\n(DBMS_OUTPUT truncated at %d bytes)", writer.limit)
+		}
 		if err != nil {
-			log.Fatalf("Unable to read DBMS_OUTPUT: %v", err)
+			log.Printf("Unable to read DBMS_OUTPUT: %v", err)
+			result.Message += fmt.Sprintf("\n(unable to read all of DBMS_OUTPUT: %v)", err)
 		}
-		result.Message = builder.String()
+	case DriverPostgreSQL:
+		if conn.notices == nil {
+			return
+		}
+		var warnings []string
+		result.Message, warnings = conn.notices.take()
+		result.Warnings = append(result.Warnings, warnings...)
+	}
+}
+
+// cappedWriter passes on up to limit bytes to w, then reports that it was
+// truncated and discards the rest. It keeps accepting writes, so that its
+// caller reads all of its output, such as the whole DBMS_OUTPUT buffer,
+// rather than leaving it for the next query. A limit of zero passes
+// everything on.
+type cappedWriter struct {
+	w         io.Writer
+	limit     int
+	written   int
+	truncated bool
+}
+
+func (capped *cappedWriter) Write(p []byte) (int, error) {
+	if capped.limit > 0 && capped.written+len(p) > capped.limit {
+		n, _ := capped.w.Write(p[:capped.limit-capped.written])
+		capped.written += n
+		capped.truncated = true
+		return len(p), nil
 	}
+	n, err := capped.w.Write(p)
+	capped.written += n
+	return n, err
 }
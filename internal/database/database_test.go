@@ -0,0 +1,268 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	// go-sqlite3 registers itself as sqlite3, but connections open the
+	// driver named after the database type
+	sql.Register("sqlite", &sqlite3.SQLiteDriver{})
+}
+
+// openSQLite connects to a new sqlite database for a test, running setup
+// statements on it first. It's a file rather than :memory:, which would give
+// each connection in the pool a database of its own.
+func openSQLite(t *testing.T, setup ...string) *Connection {
+	t.Helper()
+	conn := &Connection{}
+	if err := conn.Connect("sqlite", filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	for _, statement := range setup {
+		if result := conn.ExecuteQuery(statement); result.Error != "" {
+			t.Fatalf("%s: %s", statement, result.Error)
+		}
+	}
+	return conn
+}
+
+// queryIntoSetup are the statements the QueryInto tests run on a new database.
+var queryIntoSetup = []string{
+	"CREATE TABLE users (id INTEGER, user_name TEXT, email TEXT, score REAL)",
+	"INSERT INTO users VALUES (1, 'ann', 'ann@example.com', 9.5)",
+	"INSERT INTO users VALUES (2, 'bob', NULL, NULL)",
+}
+
+func TestQueryIntoStructFields(t *testing.T) {
+	conn := openSQLite(t, queryIntoSetup...)
+
+	type user struct {
+		ID       int64
+		Name     string `db:"user_name"`
+		Email    *string
+		Score    float64
+		internal string
+	}
+	var users []user
+	if err := conn.QueryInto(&users, "SELECT id, user_name, email, score FROM users WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("got %d users, want 1", len(users))
+	}
+	got := users[0]
+	if got.ID != 1 || got.Name != "ann" || got.Email == nil || *got.Email != "ann@example.com" || got.Score != 9.5 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestQueryIntoPointers(t *testing.T) {
+	conn := openSQLite(t, queryIntoSetup...)
+
+	type user struct {
+		ID   int64
+		Name string `db:"user_name"`
+	}
+	var users []*user
+	if err := conn.QueryInto(&users, "SELECT id, user_name FROM users ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Name != "ann" || users[1].Name != "bob" {
+		t.Errorf("got %v", users)
+	}
+}
+
+func TestQueryIntoMissingColumn(t *testing.T) {
+	conn := openSQLite(t, queryIntoSetup...)
+
+	// email has no field and is skipped, and Nickname has no column and is
+	// left alone
+	type user struct {
+		ID       int64
+		Nickname string
+		Ignored  string `db:"-"`
+	}
+	var users []user
+	if err := conn.QueryInto(&users, "SELECT id, email, user_name AS ignored FROM users ORDER BY id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("got %d users, want 2", len(users))
+	}
+	for i, got := range users {
+		if got.ID != int64(i+1) || got.Nickname != "" || got.Ignored != "" {
+			t.Errorf("users[%d] = %+v", i, got)
+		}
+	}
+}
+
+func TestQueryIntoNull(t *testing.T) {
+	conn := openSQLite(t, queryIntoSetup...)
+
+	type user struct {
+		Email    string
+		Score    float64
+		EmailPtr *string  `db:"email_ptr"`
+		ScorePtr *float64 `db:"score_ptr"`
+	}
+	var users []user
+	err := conn.QueryInto(&users, "SELECT email, score, email AS email_ptr, score AS score_ptr FROM users WHERE id = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("got %d users, want 1", len(users))
+	}
+	got := users[0]
+	if got.Email != "" || got.Score != 0 || got.EmailPtr != nil || got.ScorePtr != nil {
+		t.Errorf("NULLs gave %+v, want zero values and nil pointers", got)
+	}
+}
+
+func TestQueryIntoNoRows(t *testing.T) {
+	conn := openSQLite(t, queryIntoSetup...)
+
+	type user struct{ ID int64 }
+	users := []user{}
+	if err := conn.QueryInto(&users, "SELECT id FROM users WHERE id > 10"); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Errorf("got %d users, want none", len(users))
+	}
+}
+
+func TestQueryIntoBadDest(t *testing.T) {
+	conn := openSQLite(t, queryIntoSetup...)
+
+	type user struct{ ID int64 }
+	var users []user
+	var ids []int64
+	for _, dest := range []any{users, &ids, user{}} {
+		err := conn.QueryInto(dest, "SELECT id FROM users")
+		if err == nil || !strings.HasPrefix(err.Error(), "QueryInto needs") {
+			t.Errorf("QueryInto(%T) = %v, want an error", dest, err)
+		}
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		dbType int
+		name   string
+		want   string
+	}{
+		{DriverPostgreSQL, "orders", `"orders"`},
+		{DriverPostgreSQL, `my"table`, `"my""table"`},
+		{DriverOracle, "Order", `"Order"`},
+		{DriverSQLite, `a""b`, `"a""""b"`},
+		{DriverSnowflake, `x"`, `"x"""`},
+		{DriverDuckDB, "select", `"select"`},
+		{DriverMySQL, "orders", "`orders`"},
+		{DriverMySQL, "my`table", "`my``table`"},
+		{DriverMySQL, `back\slash`, "`back\\slash`"},
+		{DriverSqlServer, "orders", "[orders]"},
+		{DriverSqlServer, "a]b", "[a]]b]"},
+		{DriverSqlServer, "[x]", "[[x]]]"},
+	}
+	for _, test := range tests {
+		if got := QuoteIdentifier(test.dbType, test.name); got != test.want {
+			t.Errorf("QuoteIdentifier(%s, %q) = %s, want %s", DBTypeString(test.dbType), test.name, got, test.want)
+		}
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := []struct {
+		dbType int
+		value  string
+		want   string
+	}{
+		{DriverPostgreSQL, "plain", "'plain'"},
+		{DriverPostgreSQL, "it's", "'it''s'"},
+		{DriverPostgreSQL, `back\slash`, `'back\slash'`},
+		{DriverOracle, "''", "''''''"},
+		{DriverSQLite, "it's", "'it''s'"},
+		{DriverSnowflake, "it's", "'it''s'"},
+		{DriverDuckDB, "it's", "'it''s'"},
+		{DriverMySQL, "it's", "'it''s'"},
+		{DriverMySQL, `back\slash`, `'back\\slash'`},
+		{DriverMySQL, `\'`, `'\\'''`},
+		{DriverSqlServer, "it's", "N'it''s'"},
+		{DriverSqlServer, "[x]", "N'[x]'"},
+	}
+	for _, test := range tests {
+		if got := QuoteLiteral(test.dbType, test.value); got != test.want {
+			t.Errorf("QuoteLiteral(%s, %q) = %s, want %s", DBTypeString(test.dbType), test.value, got, test.want)
+		}
+	}
+}
+
+func TestQuoteName(t *testing.T) {
+	tests := []struct {
+		dbType int
+		name   string
+		want   string
+	}{
+		{DriverPostgreSQL, "orders", "orders"},
+		{DriverPostgreSQL, "sales.orders", "sales.orders"},
+		{DriverPostgreSQL, "sales.Order Items", `sales."Order Items"`},
+		{DriverPostgreSQL, `my"schema.t`, `"my""schema".t`},
+		{DriverOracle, "hr.2024_hires", `hr."2024_hires"`},
+		{DriverMySQL, "shop.order items", "shop.`order items`"},
+		{DriverSqlServer, "dbo.order]s", "dbo.[order]]s]"},
+		{DriverSQLite, "main.t-1", `main."t-1"`},
+	}
+	for _, test := range tests {
+		if got := QuoteName(test.dbType, test.name); got != test.want {
+			t.Errorf("QuoteName(%s, %q) = %s, want %s", DBTypeString(test.dbType), test.name, got, test.want)
+		}
+	}
+}
+
+func TestOnQuery(t *testing.T) {
+	conn := openSQLite(t, "CREATE TABLE t (id INTEGER)")
+	var sent []string
+	conn.Options.OnQuery = func(query string) { sent = append(sent, query) }
+
+	conn.ExecuteQuery("SELECT id FROM t")
+	conn.QueryColumns("SELECT id FROM t")
+	conn.Explain("SELECT id FROM t")
+	if _, err := conn.InsertResult("u", &TypedResult{Columns: []string{"id"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"SELECT id FROM t",
+		"SELECT * FROM (SELECT id FROM t) q WHERE 1 = 0",
+		"EXPLAIN SELECT id FROM t",
+		`CREATE TABLE u ("id" TEXT)`,
+		`INSERT INTO u ("id") VALUES (?)`,
+	}
+	if !slices.Equal(sent, want) {
+		t.Errorf("OnQuery was passed %q, want %q", sent, want)
+	}
+}
+
+func TestInsertResultReadOnly(t *testing.T) {
+	conn := openSQLite(t, "CREATE TABLE t (id INTEGER)")
+	conn.Options.ReadOnly = true
+
+	result := &TypedResult{Columns: []string{"id"}, Rows: [][]any{{int64(1)}}}
+	if _, err := conn.InsertResult("t", result); err == nil {
+		t.Fatal("InsertResult wrote to a read-only connection")
+	}
+	conn.Options.ReadOnly = false
+	if rows := conn.ExecuteQuery("SELECT COUNT(*) FROM t"); rows.Rows[0].Values[0] != "0" {
+		t.Errorf("t has %s rows, want 0", rows.Rows[0].Values[0])
+	}
+}
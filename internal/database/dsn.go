@@ -0,0 +1,250 @@
+package database
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// readOnlySessionOptions are the connection string options that make every
+// session in the pool read-only, for drivers that have one. SQL server's only
+// asks for a read-only replica, which a server without one ignores, and the
+// driver wants the connection string to name the database along with it.
+var readOnlySessionOptions = map[int][2]string{
+	DriverPostgreSQL: {"default_transaction_read_only", "on"},
+	DriverMySQL:      {"transaction_read_only", "1"},
+	DriverSQLite:     {"_query_only", "1"},
+	DriverDuckDB:     {"access_mode", "READ_ONLY"},
+	DriverSqlServer:  {"ApplicationIntent", "ReadOnly"},
+}
+
+// withReadOnly returns a copy of options with the driver's read-only session
+// option added, if it has one.
+func withReadOnly(driver int, options map[string]string) map[string]string {
+	option, ok := readOnlySessionOptions[driver]
+	if !ok {
+		return options
+	}
+	merged := map[string]string{option[0]: option[1]}
+	for key, value := range options {
+		merged[key] = value
+	}
+	return merged
+}
+
+// withSchema returns the connection string and a copy of options changed so
+// that every session starts in schema. Setting it when connecting, rather than
+// with a statement, means it applies to all the connections in the pool.
+func withSchema(driver int, dsn string, options map[string]string, schema string) (string, map[string]string, error) {
+	var key, value string
+	switch driver {
+	case DriverPostgreSQL:
+		// a comma-separated list of schemas is allowed
+		key, value = "search_path", schema
+	case DriverOracle:
+		key, value = "alterSession", "CURRENT_SCHEMA="+schema
+	case DriverSnowflake, DriverDuckDB:
+		key, value = "schema", schema
+	case DriverMySQL:
+		// the database is part of the path rather than an option
+		config, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid mysql connection string: %w", err)
+		}
+		config.DBName = schema
+		return config.FormatDSN(), options, nil
+	default:
+		return "", nil, fmt.Errorf("changing the schema is not supported for %s", DBTypeString(driver))
+	}
+
+	merged := map[string]string{key: value}
+	for key, value := range options {
+		merged[key] = value
+	}
+	return dsn, merged, nil
+}
+
+// withClientEncoding returns a copy of options with the option that sets the
+// character set text is sent to and from the database in. Postgres text can
+// only be read as UTF8, which the server converts to from the database's own
+// encoding.
+func withClientEncoding(driver int, options map[string]string, encoding string) (map[string]string, error) {
+	var key string
+	switch driver {
+	case DriverPostgreSQL:
+		if normalized := strings.ToUpper(strings.ReplaceAll(encoding, "-", "")); normalized != "UTF8" {
+			return nil, fmt.Errorf("the postgres driver only reads text as UTF8, which the server converts to from the database's encoding")
+		}
+		key, encoding = "client_encoding", "UTF8"
+	case DriverMySQL:
+		// the driver sends SET NAMES for it on every new connection
+		key = "charset"
+	case DriverOracle:
+		// the character set of the client, which otherwise comes from
+		// NLS_LANG, or is UTF-8 if that isn't set
+		key = "charset"
+	default:
+		return nil, fmt.Errorf("setting the client encoding is not supported for %s", DBTypeString(driver))
+	}
+
+	merged := map[string]string{key: encoding}
+	for key, value := range options {
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// applyDriverOptions merges extra driver options into a connection string,
+// using whichever syntax the driver's connection string is written in.
+func applyDriverOptions(driver int, dsn string, options map[string]string) (string, error) {
+	if len(options) == 0 {
+		return dsn, nil
+	}
+
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	switch driver {
+	case DriverPostgreSQL:
+		if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+			return appendQueryParams(dsn, keys, options), nil
+		}
+		// key=value connection string
+		for _, key := range keys {
+			dsn += fmt.Sprintf(" %s='%s'", key, strings.ReplaceAll(options[key], "'", `\'`))
+		}
+		return strings.TrimSpace(dsn), nil
+
+	case DriverSqlServer:
+		if strings.HasPrefix(dsn, "sqlserver://") {
+			return appendQueryParams(dsn, keys, options), nil
+		}
+		// ADO style connection string
+		dsn = strings.TrimSuffix(dsn, ";")
+		for _, key := range keys {
+			dsn += fmt.Sprintf(";%s=%s", key, options[key])
+		}
+		return dsn, nil
+
+	case DriverOracle:
+		if !strings.Contains(dsn, "=") {
+			return "", fmt.Errorf("driver options need an oracle connection string in the key=value form, e.g. user=scott password=tiger connectString=host/service")
+		}
+		for _, key := range keys {
+			dsn += fmt.Sprintf(" %s=%q", key, options[key])
+		}
+		return dsn, nil
+
+	case DriverMySQL, DriverSQLite, DriverSnowflake, DriverDuckDB:
+		return appendQueryParams(dsn, keys, options), nil
+	}
+
+	return "", fmt.Errorf("driver options are not supported for %s", DBTypeString(driver))
+}
+
+// appendQueryParams adds options to the query string of a URL-like
+// connection string.
+func appendQueryParams(dsn string, keys []string, options map[string]string) string {
+	for _, key := range keys {
+		separator := "&"
+		if !strings.Contains(dsn, "?") {
+			separator = "?"
+		}
+		dsn += separator + url.QueryEscape(key) + "=" + url.QueryEscape(options[key])
+	}
+	return dsn
+}
+
+// DSNParts are the pieces of a connection string, as asked for by -build-dsn.
+// For snowflake Host is the account identifier; for sqlite and duckdb only
+// Database, the path of the file, is used.
+type DSNParts struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Database string
+}
+
+// DefaultPorts are the ports the databases listen on unless set up otherwise.
+var DefaultPorts = map[int]string{
+	DriverOracle:     "1521",
+	DriverMySQL:      "3306",
+	DriverPostgreSQL: "5432",
+	DriverSqlServer:  "1433",
+}
+
+// BuildDSN assembles a connection string in the form the driver expects from
+// its parts. Parts left empty are left out where the driver allows it.
+func BuildDSN(driver int, parts DSNParts) (string, error) {
+	hostPort := parts.Host
+	if parts.Port != "" {
+		hostPort = net.JoinHostPort(parts.Host, parts.Port)
+	}
+	var user *url.Userinfo
+	if parts.User != "" {
+		user = url.UserPassword(parts.User, parts.Password)
+		if parts.Password == "" {
+			user = url.User(parts.User)
+		}
+	}
+
+	switch driver {
+	case DriverPostgreSQL:
+		dsn := url.URL{Scheme: "postgres", User: user, Host: hostPort, Path: "/" + parts.Database}
+		return dsn.String(), nil
+
+	case DriverMySQL:
+		config := mysql.NewConfig()
+		config.User, config.Passwd, config.DBName = parts.User, parts.Password, parts.Database
+		config.Net, config.Addr = "tcp", hostPort
+		return config.FormatDSN(), nil
+
+	case DriverSqlServer:
+		dsn := url.URL{Scheme: "sqlserver", User: user, Host: hostPort}
+		if parts.Database != "" {
+			dsn.RawQuery = url.Values{"database": {parts.Database}}.Encode()
+		}
+		return dsn.String(), nil
+
+	case DriverOracle:
+		// Database is the service name
+		connectString := hostPort
+		if parts.Database != "" {
+			connectString += "/" + parts.Database
+		}
+		return fmt.Sprintf("user=%q password=%q connectString=%q", parts.User, parts.Password, connectString), nil
+
+	case DriverSnowflake:
+		if parts.Host == "" {
+			return "", fmt.Errorf("a snowflake connection string needs the account")
+		}
+		dsn := url.URL{User: user, Host: parts.Host, Path: "/" + parts.Database}
+		return strings.TrimPrefix(dsn.String(), "//"), nil
+
+	case DriverSQLite:
+		if parts.Database == "" {
+			return "", fmt.Errorf("a sqlite connection string needs the path of the database file")
+		}
+		return parts.Database, nil
+
+	case DriverDuckDB:
+		if parts.Database == "" {
+			return memoryDSN, nil
+		}
+		return parts.Database, nil
+	}
+
+	return "", fmt.Errorf("building a connection string is not supported for %s", DBTypeString(driver))
+}
+
+// memoryDSN is the connection string of an in-memory database, for sqlite and
+// duckdb.
+const memoryDSN = ":memory:"
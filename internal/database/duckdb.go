@@ -0,0 +1,86 @@
+package database
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+// duckDBDSN returns dsn in the form the duckdb driver expects. It reads the
+// connection string as a URL, which :memory: can't be parsed as, so the
+// in-memory database is opened by leaving out the path instead.
+func duckDBDSN(dsn string) string {
+	if rest, ok := strings.CutPrefix(dsn, memoryDSN); ok && (rest == "" || rest[0] == '?') {
+		return rest
+	}
+	return dsn
+}
+
+// duckDBValue converts the values the duckdb driver scans into types of its
+// own, or into raw bytes, into ones that print and bind like any other
+// driver's: a DECIMAL into its exact digits, and an INTERVAL and a UUID, of
+// the database type typeName, into text. Other values are returned as they
+// are.
+func duckDBValue(val any, typeName string) any {
+	switch val := val.(type) {
+	case duckdb.Decimal:
+		return decimalString(val.Value, int(val.Scale))
+	case duckdb.Interval:
+		return intervalString(val)
+	case []byte:
+		if typeName == "UUID" && len(val) == 16 {
+			return fmt.Sprintf("%x-%x-%x-%x-%x", val[0:4], val[4:6], val[6:8], val[8:10], val[10:16])
+		}
+	}
+	return val
+}
+
+// decimalString writes the unscaled value of a decimal with scale digits
+// after the point.
+func decimalString(value *big.Int, scale int) string {
+	if value == nil {
+		return "0"
+	}
+	sign, digits := "", value.String()
+	if value.Sign() < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if scale <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// intervalString writes an interval the way duckdb itself does, such as
+// "1 year 2 months 3 days 04:05:06.5".
+func intervalString(interval duckdb.Interval) string {
+	var parts []string
+	plural := func(n int64, unit string) {
+		if n == 1 || n == -1 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, unit))
+		} else if n != 0 {
+			parts = append(parts, fmt.Sprintf("%d %ss", n, unit))
+		}
+	}
+	plural(int64(interval.Months/12), "year")
+	plural(int64(interval.Months%12), "month")
+	plural(int64(interval.Days), "day")
+
+	if micros := interval.Micros; micros != 0 || len(parts) == 0 {
+		sign := ""
+		if micros < 0 {
+			sign, micros = "-", -micros
+		}
+		clock := fmt.Sprintf("%s%02d:%02d:%02d", sign, micros/3_600_000_000, micros/60_000_000%60, micros/1_000_000%60)
+		if fraction := micros % 1_000_000; fraction != 0 {
+			clock += strings.TrimRight(fmt.Sprintf(".%06d", fraction), "0")
+		}
+		parts = append(parts, clock)
+	}
+	return strings.Join(parts, " ")
+}
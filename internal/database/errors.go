@@ -0,0 +1,122 @@
+package database
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/godror/godror"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrTooManyConnections is wrapped by the error Connect returns when the
+// database refuses new connections because it is at capacity.
+var ErrTooManyConnections = errors.New("the database server has no free connections")
+
+// oracleTooManyConnectionCodes are the ORA- errors raised when the database or
+// its listener can't take another session.
+var oracleTooManyConnectionCodes = map[int]bool{
+	18:    true, // maximum number of sessions exceeded
+	20:    true, // maximum number of processes exceeded
+	12516: true, // listener could not find available handler
+	12519: true, // no appropriate service handler found
+	12520: true, // listener could not find available handler for requested type of server
+}
+
+// isTooManyConnections reports whether err is a driver error saying the
+// database has reached its connection limit.
+func isTooManyConnections(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1040
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "53300"
+	}
+
+	var oraErr *godror.OraErr
+	if errors.As(err, &oraErr) {
+		return oracleTooManyConnectionCodes[oraErr.Code()]
+	}
+
+	// the remaining drivers don't expose a code we can check
+	return strings.Contains(strings.ToLower(err.Error()), "too many connections")
+}
+
+// mysqlNearPattern picks the text an error was found at, and its line, out of
+// a mysql syntax error: "... near 'form orders' at line 1".
+var mysqlNearPattern = regexp.MustCompile(`(?s)near '(.*)' at line (\d+)$`)
+
+// sqliteNearPattern picks the token an error was found at out of a sqlite
+// syntax error: `near "form": syntax error`.
+var sqliteNearPattern = regexp.MustCompile(`^near "(.*)": syntax error$`)
+
+// errorPosition returns the 1-based character position in query at which the
+// database reported err, or zero if it didn't report one. Postgres and oracle
+// give the position; for mysql and sqlite it's found from the text the error
+// quotes.
+func errorPosition(err error, query string) int {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		position, _ := strconv.Atoi(pqErr.Position)
+		return position
+	}
+
+	var oraErr *godror.OraErr
+	if errors.As(err, &oraErr) {
+		// a byte offset from 0, though 0 is also what's given when there's
+		// no position
+		if offset := oraErr.Offset(); offset > 0 && offset <= len(query) {
+			return utf8.RuneCountInString(query[:offset]) + 1
+		}
+		return 0
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		match := mysqlNearPattern.FindStringSubmatch(mysqlErr.Message)
+		if match == nil {
+			return 0
+		}
+		// the offset of the line the error is on
+		line, _ := strconv.Atoi(match[2])
+		start := 0
+		for ; line > 1; line-- {
+			next := strings.IndexByte(query[start:], '\n')
+			if next < 0 {
+				return 0
+			}
+			start += next + 1
+		}
+		return textPosition(query, start, match[1])
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		if match := sqliteNearPattern.FindStringSubmatch(err.Error()); match != nil {
+			return textPosition(query, 0, match[1])
+		}
+	}
+	return 0
+}
+
+// textPosition returns the 1-based character position of the first
+// occurrence of text in query at or after the byte offset start, or zero if
+// it isn't there. Empty text, which is how the end of the query is quoted,
+// is positioned just past the end.
+func textPosition(query string, start int, text string) int {
+	if text == "" {
+		return utf8.RuneCountInString(strings.TrimRight(query, "; \t\r\n")) + 1
+	}
+	index := strings.Index(query[start:], text)
+	if index < 0 {
+		return 0
+	}
+	return utf8.RuneCountInString(query[:start+index]) + 1
+}
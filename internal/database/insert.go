@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// createColumnTypes are the column types, by database type, that InsertResult
+// creates a table with for each kind of value.
+var createColumnTypes = map[int]map[string]string{
+	DriverPostgreSQL: {"integer": "BIGINT", "number": "NUMERIC", "boolean": "BOOLEAN", "time": "TIMESTAMP", "binary": "BYTEA", "": "TEXT"},
+	DriverMySQL:      {"integer": "BIGINT", "number": "DOUBLE", "boolean": "BOOLEAN", "time": "DATETIME(6)", "binary": "LONGBLOB", "": "LONGTEXT"},
+	DriverOracle:     {"integer": "NUMBER(19)", "number": "NUMBER", "boolean": "NUMBER(1)", "time": "TIMESTAMP", "binary": "BLOB", "": "VARCHAR2(4000)"},
+	DriverSqlServer:  {"integer": "BIGINT", "number": "FLOAT", "boolean": "BIT", "time": "DATETIME2", "binary": "VARBINARY(MAX)", "": "NVARCHAR(MAX)"},
+	DriverSQLite:     {"integer": "INTEGER", "number": "REAL", "boolean": "INTEGER", "time": "TEXT", "binary": "BLOB", "": "TEXT"},
+	DriverSnowflake:  {"integer": "NUMBER(19)", "number": "FLOAT", "boolean": "BOOLEAN", "time": "TIMESTAMP_NTZ", "binary": "BINARY", "": "VARCHAR"},
+	DriverDuckDB:     {"integer": "BIGINT", "number": "DOUBLE", "boolean": "BOOLEAN", "time": "TIMESTAMP", "binary": "BLOB", "": "VARCHAR"},
+}
+
+// InsertResult inserts the rows of result into table, first creating it with
+// a column for each of the result's if it doesn't exist yet. The table is
+// written as it would be in a query, so it may name a schema. The rows are
+// inserted in the open transaction if there is one, or else in one of their
+// own, though on oracle and mysql a table can't be created inside the open
+// transaction. It reports whether the table was created.
+func (conn *Connection) InsertResult(table string, result *TypedResult) (bool, error) {
+	types, ok := createColumnTypes[conn.dbType]
+	if !ok {
+		return false, fmt.Errorf("inserting results is not supported for %s", DBTypeString(conn.dbType))
+	}
+	if conn.Options.ReadOnly {
+		return false, fmt.Errorf("insert rejected: the connection is read-only")
+	}
+
+	context, cancelFunc := context.WithTimeout(conn.context, conn.queryTimeout())
+	defer cancelFunc()
+
+	columns := make([]string, len(result.Columns))
+	placeholders := make([]string, len(result.Columns))
+	for i, col := range result.Columns {
+		columns[i] = QuoteIdentifier(conn.dbType, col)
+		placeholders[i] = placeholder(conn.dbType, i+1)
+	}
+
+	tx := conn.tx
+	if tx == nil {
+		var err error
+		if tx, err = conn.db.BeginTx(context, nil); err != nil {
+			return false, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+	}
+
+	// the check runs outside the transaction, since in postgres a failed
+	// statement would abort it
+	created := false
+	if !conn.tableExists(context, table) {
+		// oracle and mysql commit the open transaction before they create a
+		// table, which would commit whatever else it holds along with it
+		if tx == conn.tx && (conn.dbType == DriverOracle || conn.dbType == DriverMySQL) {
+			return false, fmt.Errorf("%s doesn't exist, and creating it would commit the open transaction; create it, or end the transaction, first", table)
+		}
+		definitions := make([]string, len(columns))
+		for i, col := range columns {
+			var columnType *sql.ColumnType
+			if i < len(result.ColumnTypes) {
+				columnType = result.ColumnTypes[i]
+			}
+			definitions[i] = col + " " + types[valueKind(columnType)]
+		}
+		create := fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(definitions, ", "))
+		conn.sent(create)
+		if _, err := tx.ExecContext(context, create); err != nil {
+			return false, fmt.Errorf("failed to create %s: %w", table, err)
+		}
+		created = true
+	}
+
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	conn.sent(statement)
+	insert, err := tx.PrepareContext(context, statement)
+	if err != nil {
+		return false, err
+	}
+	defer insert.Close()
+
+	for i, values := range result.Rows {
+		args := make([]any, len(columns))
+		for j := range args {
+			if j < len(values) {
+				args[j] = bindValue(values[j])
+			}
+			if b, ok := args[j].(bool); ok && conn.dbType == DriverOracle {
+				// stored as NUMBER(1)
+				args[j] = map[bool]int64{false: 0, true: 1}[b]
+			}
+		}
+		if _, err = insert.ExecContext(context, args...); err != nil {
+			return false, fmt.Errorf("failed to insert row %d: %w", i+1, err)
+		}
+	}
+
+	if tx != conn.tx {
+		if err = tx.Commit(); err != nil {
+			return false, fmt.Errorf("failed to commit: %w", err)
+		}
+	}
+	return created, nil
+}
+
+// tableExists reports whether table can be queried.
+func (conn *Connection) tableExists(ctx context.Context, table string) bool {
+	rows, err := conn.db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s WHERE 1 = 0", table))
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// placeholder returns the bind placeholder for the nth argument of a
+// statement, counted from 1, in the form the driver expects.
+func placeholder(dbType int, n int) string {
+	switch dbType {
+	case DriverPostgreSQL:
+		return fmt.Sprintf("$%d", n)
+	case DriverOracle:
+		return fmt.Sprintf(":%d", n)
+	case DriverSqlServer:
+		return fmt.Sprintf("@p%d", n)
+	}
+	return "?"
+}
+
+// valueKind is like columnKind, but tells times and binary values apart from
+// text too, for picking the type of a column to hold them.
+func valueKind(columnType *sql.ColumnType) string {
+	if columnType == nil {
+		return ""
+	}
+	if kind := columnKind(columnType); kind != "" {
+		return kind
+	}
+
+	if scanType := columnType.ScanType(); scanType != nil {
+		switch {
+		case scanType == reflect.TypeOf(time.Time{}), scanType == reflect.TypeOf(sql.NullTime{}):
+			return "time"
+		case scanType.Kind() == reflect.Slice && scanType.Elem().Kind() == reflect.Uint8:
+			// drivers scan text into bytes too, so go by the type's name
+			name := strings.ToUpper(columnType.DatabaseTypeName())
+			if strings.Contains(name, "BLOB") || strings.Contains(name, "BINARY") || name == "BYTEA" || name == "RAW" {
+				return "binary"
+			}
+		}
+	}
+
+	name := strings.ToUpper(columnType.DatabaseTypeName())
+	switch {
+	case strings.Contains(name, "DATE"), strings.Contains(name, "TIME"):
+		return "time"
+	case strings.Contains(name, "BLOB"), strings.Contains(name, "BINARY"), name == "BYTEA", name == "RAW":
+		return "binary"
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// listenPingInterval is how often an idle listener checks that its
+// connection is still alive.
+const listenPingInterval = 90 * time.Second
+
+// Notification is a postgres NOTIFY received by Listen.
+type Notification struct {
+	Channel string
+	Payload string
+	PID     int
+}
+
+// Listen subscribes to a postgres notification channel and calls onNotify for
+// each notification until ctx is cancelled. It uses a connection of its own
+// rather than one from the pool, since the subscription lasts as long as the
+// connection does.
+func (conn *Connection) Listen(ctx context.Context, channel string, onNotify func(Notification)) error {
+	if conn.dbType != DriverPostgreSQL {
+		return fmt.Errorf("LISTEN is not supported for %s", DBTypeString(conn.dbType))
+	}
+
+	listener := pq.NewListener(conn.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			log.Printf("Lost the listener connection: %v", err)
+		case pq.ListenerEventReconnected:
+			log.Println("Reconnected the listener; notifications sent while disconnected were missed")
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(channel); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", channel, err)
+	}
+	log.Printf("Listening for notifications on %s", channel)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-listener.Notify:
+			// nil is sent after reconnecting
+			if notification != nil {
+				onNotify(Notification{
+					Channel: notification.Channel,
+					Payload: notification.Extra,
+					PID:     notification.BePid,
+				})
+			}
+		case <-time.After(listenPingInterval):
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"sqlrepl/internal/protocol"
+)
+
+// LocalStore is an in-memory SQLite database that query results from any
+// connection can be copied into, so that they can be joined and aggregated
+// locally.
+type LocalStore struct {
+	db *sql.DB
+
+	// timeout is how long a query may run before it's stopped
+	timeout time.Duration
+}
+
+// OpenLocalStore opens an empty in-memory store, whose queries are stopped
+// after timeout, or DefaultQueryTimeout if it's zero.
+func OpenLocalStore(timeout time.Duration) (*LocalStore, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local store: %w", err)
+	}
+	// every connection to :memory: is a separate database, so keep to one
+	db.SetMaxOpenConns(1)
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	return &LocalStore{db: db, timeout: timeout}, nil
+}
+
+// Load creates table name from result, replacing any table already there.
+// Column types are inferred from the types the source driver reported.
+func (store *LocalStore) Load(name string, result *TypedResult) error {
+	columns := make([]string, len(result.Columns))
+	definitions := make([]string, len(result.Columns))
+	seen := map[string]int{}
+	for i, col := range result.Columns {
+		// sqlite won't create a table with two columns of the same name
+		seen[strings.ToLower(col)]++
+		if n := seen[strings.ToLower(col)]; n > 1 {
+			col = fmt.Sprintf("%s_%d", col, n)
+		}
+		columns[i] = QuoteIdentifier(DriverSQLite, col)
+		definitions[i] = columns[i] + " " + sqliteColumnType(result.ColumnTypes, i)
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	table := QuoteIdentifier(DriverSQLite, name)
+	if _, err = tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for i, values := range result.Rows {
+		args := make([]any, len(values))
+		for j, val := range values {
+			args[j] = bindValue(val)
+		}
+		if _, err = insert.Exec(args...); err != nil {
+			return fmt.Errorf("failed to load row %d: %w", i+1, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Query runs a query against the local store.
+func (store *LocalStore) Query(query string) *protocol.QueryResult {
+	context, cancelFunc := context.WithTimeout(context.Background(), store.timeout)
+	defer cancelFunc()
+
+	rows, err := store.db.QueryContext(context, query)
+	if err != nil {
+		return &protocol.QueryResult{Error: err.Error()}
+	}
+	defer rows.Close()
+	return newQueryResult(readRows(rows))
+}
+
+// Close discards the store and everything loaded into it.
+func (store *LocalStore) Close() error {
+	return store.db.Close()
+}
+
+// sqliteColumnType picks a SQLite column type for column i from the type
+// the source driver reported for it.
+func sqliteColumnType(columnTypes []*sql.ColumnType, i int) string {
+	if i >= len(columnTypes) {
+		return ""
+	}
+	if scanType := columnTypes[i].ScanType(); scanType != nil {
+		switch scanType.Kind() {
+		case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return "INTEGER"
+		case reflect.Float32, reflect.Float64:
+			return "REAL"
+		case reflect.String:
+			return "TEXT"
+		case reflect.Slice:
+			if scanType.Elem().Kind() == reflect.Uint8 {
+				return "BLOB"
+			}
+		}
+	}
+
+	// fall back on the name of the type in the source database, using
+	// SQLite's own affinity rules
+	name := strings.ToUpper(columnTypes[i].DatabaseTypeName())
+	switch {
+	case strings.Contains(name, "INT"):
+		return "INTEGER"
+	case strings.Contains(name, "CHAR"), strings.Contains(name, "TEXT"), strings.Contains(name, "CLOB"):
+		return "TEXT"
+	case strings.Contains(name, "BLOB"), strings.Contains(name, "BINARY"), name == "BYTEA", name == "RAW":
+		return "BLOB"
+	case strings.Contains(name, "REAL"), strings.Contains(name, "FLOA"), strings.Contains(name, "DOUB"):
+		return "REAL"
+	case strings.Contains(name, "NUM"), strings.Contains(name, "DEC"):
+		return "NUMERIC"
+	}
+	return ""
+}
+
+// bindValue converts a value scanned from one driver into one that another,
+// such as the SQLite driver, can bind.
+func bindValue(val any) any {
+	switch v := val.(type) {
+	case nil, int64, float64, bool, string, []byte, time.Time:
+		return v
+	case int, int8, int16, int32, uint8, uint16, uint32:
+		return reflect.ValueOf(v).Convert(reflect.TypeOf(int64(0))).Interface()
+	case float32:
+		return float64(v)
+	}
+	return FormatValue(val)
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+)
+
+// Pools lets connections opened with the same parameters share one *sql.DB,
+// rather than each opening a pool of its own. A pool is closed once the last
+// connection using it is closed. The zero value is not usable; use NewPools.
+type Pools struct {
+	mu    sync.Mutex
+	pools map[poolKey]*sharedPool
+}
+
+// poolKey identifies a pool by the driver and the connection string as it is
+// opened, after the options are applied, so that the same database reached
+// through differently written parameters still gets one pool.
+type poolKey struct {
+	driver int
+	dsn    string
+}
+
+type sharedPool struct {
+	db   *sql.DB
+	refs int
+}
+
+// NewPools returns an empty set of shared pools.
+func NewPools() *Pools {
+	return &Pools{pools: map[poolKey]*sharedPool{}}
+}
+
+// acquire returns the pool for key, opening it with open if no connection is
+// using one yet. Every successful acquire must be matched by a release.
+func (pools *Pools) acquire(key poolKey, open func() (*sql.DB, error)) (*sql.DB, error) {
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+
+	if pool, ok := pools.pools[key]; ok {
+		pool.refs++
+		return pool.db, nil
+	}
+
+	db, err := open()
+	if err != nil {
+		return nil, err
+	}
+	pools.pools[key] = &sharedPool{db: db, refs: 1}
+	return db, nil
+}
+
+// release gives up a connection's use of the pool for key, closing the pool if
+// nothing else is using it.
+func (pools *Pools) release(key poolKey) error {
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+
+	pool, ok := pools.pools[key]
+	if !ok {
+		return nil
+	}
+	pool.refs--
+	if pool.refs > 0 {
+		return nil
+	}
+	delete(pools.pools, key)
+	log.Printf("Closing the shared pool, the last connection using it has closed")
+	return pool.db.Close()
+}
@@ -0,0 +1,235 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"sqlrepl/internal/protocol"
+)
+
+// TypedResult holds the result of a query with each value left as it was
+// scanned from the driver, along with the column types the driver reported.
+type TypedResult struct {
+	Columns     []string
+	ColumnTypes []*sql.ColumnType
+	Rows        [][]any
+	Message     string
+	Warnings    []string
+
+	// ExecuteTime is how long the database took to have the first rows
+	// ready, and FetchTime how long reading them all took after that
+	ExecuteTime time.Duration
+	FetchTime   time.Duration
+}
+
+// QueryResult converts the typed result into the stringified form sent over
+// the wire and printed by the REPL.
+func (result *TypedResult) QueryResult() *protocol.QueryResult {
+	protoResult := &protocol.QueryResult{
+		Columns:     result.Columns,
+		ColumnTypes: make([]string, len(result.Columns)),
+		Message:     result.Message,
+		Warnings:    result.Warnings,
+
+		ExecuteMicros: result.ExecuteTime.Microseconds(),
+		FetchMicros:   result.FetchTime.Microseconds(),
+	}
+	for i := range protoResult.ColumnTypes {
+		if i < len(result.ColumnTypes) {
+			protoResult.ColumnTypes[i] = columnKind(result.ColumnTypes[i])
+		}
+	}
+
+	for n, values := range result.Rows {
+		// every row should have a value for each column, but pad or cut
+		// short any that don't rather than hand on a ragged result
+		if len(values) != len(result.Columns) {
+			log.Printf("Warning: row %d has %d values for %d columns", n+1, len(values), len(result.Columns))
+		}
+		rowValues := make([]string, len(result.Columns))
+		for i := range rowValues {
+			var val any
+			if i < len(values) {
+				val = values[i]
+			}
+			rowValues[i] = FormatValue(val)
+		}
+		protoResult.Rows = append(protoResult.Rows, &protocol.Row{Values: rowValues})
+	}
+
+	return protoResult
+}
+
+// warnTruncated adds a warning for each column with values
+// maxFieldSize bytes or longer, which the driver may have cut short. A
+// maxFieldSize of zero checks nothing.
+func (result *TypedResult) warnTruncated(maxFieldSize int) {
+	if maxFieldSize <= 0 {
+		return
+	}
+
+	for i, col := range result.Columns {
+		count := 0
+		for _, values := range result.Rows {
+			if i >= len(values) {
+				continue
+			}
+			switch val := values[i].(type) {
+			case string:
+				if len(val) >= maxFieldSize {
+					count++
+				}
+			case []byte:
+				if len(val) >= maxFieldSize {
+					count++
+				}
+			}
+		}
+		if count > 0 {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("column %s may have been truncated (%d of %d values reached %d bytes)",
+				col, count, len(result.Rows), maxFieldSize))
+		}
+	}
+}
+
+// readRows scans every row from rows, keeping the rows gathered so far if
+// reading fails part way through.
+func readRows(rows *sql.Rows) (*TypedResult, error) {
+	result := &TypedResult{}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result.Columns = columns
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	result.ColumnTypes = columnTypes
+
+	_, err = eachRow(rows, len(columns), func(values []any) error {
+		result.Rows = append(result.Rows, values)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// eachRow scans rows one at a time and passes each to fn, stopping at the
+// first error. It returns how many rows were passed to fn.
+func eachRow(rows *sql.Rows, columns int, fn func(values []any) error) (n int, err error) {
+	typeNames := columnTypeNames(rows, columns)
+	for rows.Next() {
+		values, err := scanRow(rows, typeNames)
+		if err != nil {
+			return n, fmt.Errorf("failed to scan row %d: %w", n+1, err)
+		}
+		if err = fn(values); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if err = rows.Err(); err != nil {
+		return n, fmt.Errorf("failed after reading %d rows: %w", n, err)
+	}
+	return n, nil
+}
+
+// columnTypeNames returns the database's names for the types of the columns
+// of rows, which scanRow needs, or empty names if the driver can't say.
+func columnTypeNames(rows *sql.Rows, columns int) []string {
+	typeNames := make([]string, columns)
+	if columnTypes, err := rows.ColumnTypes(); err == nil {
+		for i := range typeNames {
+			if i < len(columnTypes) {
+				typeNames[i] = columnTypes[i].DatabaseTypeName()
+			}
+		}
+	}
+	return typeNames
+}
+
+// scanRow scans the row rows is on, with a value for each of the columns
+// named in typeNames.
+func scanRow(rows *sql.Rows, typeNames []string) ([]any, error) {
+	values := make([]any, len(typeNames))
+	scanArgs := make([]any, len(typeNames))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	if err := rows.Scan(scanArgs...); err != nil {
+		return nil, err
+	}
+	for i := range values {
+		values[i] = duckDBValue(values[i], typeNames[i])
+	}
+	return values, nil
+}
+
+// columnKind tells clients what kind of values a column holds, since they only
+// see them as strings: "integer", "number", "boolean", or "" for anything
+// that should stay a string.
+func columnKind(columnType *sql.ColumnType) string {
+	if scanType := columnType.ScanType(); scanType != nil {
+		// look through sql.NullInt64 and the like to the type they wrap
+		if scanType.Kind() == reflect.Struct && scanType.NumField() == 2 && scanType.Field(1).Name == "Valid" {
+			scanType = scanType.Field(0).Type
+		}
+		switch scanType.Kind() {
+		case reflect.Bool:
+			return "boolean"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return "integer"
+		case reflect.Float32, reflect.Float64:
+			return "number"
+		}
+	}
+
+	// drivers scan exact numerics into strings or bytes to keep their
+	// precision, so go by the database's name for the type, without any
+	// precision and scale like DECIMAL(18,3)
+	name, _, _ := strings.Cut(strings.ToUpper(columnType.DatabaseTypeName()), "(")
+	switch name {
+	case "NUMERIC", "DECIMAL", "NUMBER", "REAL", "FLOAT", "DOUBLE", "FLOAT4", "FLOAT8":
+		return "number"
+	case "INT", "INTEGER", "BIGINT", "SMALLINT", "TINYINT", "INT2", "INT4", "INT8":
+		return "integer"
+	case "BOOL", "BOOLEAN":
+		return "boolean"
+	}
+	return ""
+}
+
+// newQueryResult converts the outcome of a typed query into a QueryResult,
+// carrying any error in the result itself.
+func newQueryResult(result *TypedResult, err error) *protocol.QueryResult {
+	if result == nil {
+		return &protocol.QueryResult{Error: err.Error()}
+	}
+
+	protoResult := result.QueryResult()
+	if err != nil {
+		protoResult.Error = err.Error()
+	}
+	return protoResult
+}
+
+// FormatValue returns the string representation of a scanned value.
+func FormatValue(val any) string {
+	if val == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%v", val)
+}
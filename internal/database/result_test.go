@@ -0,0 +1,69 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestExecuteQueryTypedKeepsTypes(t *testing.T) {
+	conn := openSQLite(t,
+		"CREATE TABLE t (i INTEGER, f REAL, b BLOB, ok BOOLEAN, at DATETIME, missing TEXT)",
+		"INSERT INTO t VALUES (9007199254740993, 2.5, x'00ff', 1, '2024-03-01 12:30:00', NULL)",
+	)
+
+	result, err := conn.ExecuteQueryTyped("SELECT i, f, b, ok, at, missing FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(result.Rows))
+	}
+	row := result.Rows[0]
+
+	if i, ok := row[0].(int64); !ok || i != 9007199254740993 {
+		t.Errorf("i = %#v, want int64 9007199254740993", row[0])
+	}
+	if f, ok := row[1].(float64); !ok || f != 2.5 {
+		t.Errorf("f = %#v, want float64 2.5", row[1])
+	}
+	if b, ok := row[2].([]byte); !ok || !bytes.Equal(b, []byte{0x00, 0xff}) {
+		t.Errorf("b = %#v, want []byte{0x00, 0xff}", row[2])
+	}
+	if ok, isBool := row[3].(bool); !isBool || !ok {
+		t.Errorf("ok = %#v, want true", row[3])
+	}
+	want := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	if at, ok := row[4].(time.Time); !ok || !at.Equal(want) {
+		t.Errorf("at = %#v, want %v", row[4], want)
+	}
+	if row[5] != nil {
+		t.Errorf("missing = %#v, want nil", row[5])
+	}
+}
+
+func TestColumnKind(t *testing.T) {
+	conn := openSQLite(t,
+		"CREATE TABLE t (i INTEGER, big BIGINT, f REAL, d DECIMAL(10,2), ok BOOLEAN, s TEXT, b BLOB)",
+		"INSERT INTO t VALUES (1, 2, 2.5, 3.25, 0, 'x', x'00')",
+	)
+
+	result, err := conn.ExecuteQueryTyped("SELECT i, big, f, d, ok, s, b FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"integer", "integer", "number", "number", "boolean", "", ""}
+	for i, columnType := range result.ColumnTypes {
+		if kind := columnKind(columnType); kind != want[i] {
+			t.Errorf("columnKind(%s) = %q, want %q", result.Columns[i], kind, want[i])
+		}
+	}
+
+	// the kinds go with the result sent to clients
+	protoResult := result.QueryResult()
+	for i, kind := range protoResult.ColumnTypes {
+		if kind != want[i] {
+			t.Errorf("QueryResult().ColumnTypes[%d] = %q, want %q", i, kind, want[i])
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DumpSchema returns the CREATE statements for the tables in the current
+// database, or for just the named table.
+func (conn *Connection) DumpSchema(table string) ([]string, error) {
+	switch conn.dbType {
+	case DriverSQLite:
+		if table != "" {
+			return conn.queryColumn(0, "SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND tbl_name = ? ORDER BY type DESC, name", table)
+		}
+		return conn.queryColumn(0, "SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY type DESC, name")
+
+	case DriverMySQL:
+		tables := []string{table}
+		if table == "" {
+			var err error
+			if tables, err = conn.queryColumn(0, "SHOW TABLES"); err != nil {
+				return nil, err
+			}
+		}
+		var statements []string
+		for _, table := range tables {
+			ddl, err := conn.queryColumn(1, "SHOW CREATE TABLE `"+strings.ReplaceAll(table, "`", "``")+"`")
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, ddl...)
+		}
+		return statements, nil
+
+	case DriverOracle:
+		if table != "" {
+			return conn.queryColumn(0, "SELECT DBMS_METADATA.GET_DDL('TABLE', :1) FROM dual", strings.ToUpper(table))
+		}
+		return conn.queryColumn(0, "SELECT DBMS_METADATA.GET_DDL('TABLE', table_name) FROM user_tables ORDER BY table_name")
+
+	case DriverSnowflake:
+		if table != "" {
+			return conn.queryColumn(0, "SELECT GET_DDL('TABLE', ?)", table)
+		}
+		return conn.queryColumn(0, "SELECT GET_DDL('SCHEMA', CURRENT_SCHEMA())")
+
+	case DriverDuckDB:
+		if table != "" {
+			return conn.queryColumn(0, "SELECT sql FROM duckdb_tables() WHERE table_name = ? AND schema_name = current_schema()", table)
+		}
+		return conn.queryColumn(0, "SELECT sql FROM duckdb_tables() WHERE schema_name = current_schema() ORDER BY table_name")
+
+	case DriverPostgreSQL:
+		return nil, fmt.Errorf("dumping the schema is not supported for postgres; use pg_dump --schema-only")
+	}
+
+	return nil, fmt.Errorf("dumping the schema is not supported for %s", DBTypeString(conn.dbType))
+}
+
+// CurrentSchema returns the schema that unqualified names resolve to, or the
+// database for mysql. It's empty if there is none.
+func (conn *Connection) CurrentSchema() (string, error) {
+	var query string
+	switch conn.dbType {
+	case DriverPostgreSQL, DriverDuckDB:
+		query = "SELECT current_schema()"
+	case DriverMySQL:
+		query = "SELECT DATABASE()"
+	case DriverOracle:
+		query = "SELECT SYS_CONTEXT('USERENV', 'CURRENT_SCHEMA') FROM dual"
+	case DriverSqlServer:
+		query = "SELECT SCHEMA_NAME()"
+	case DriverSnowflake:
+		query = "SELECT CURRENT_SCHEMA()"
+	default:
+		return "", nil
+	}
+
+	values, err := conn.queryColumn(0, query)
+	if err != nil || len(values) == 0 || values[0] == FormatValue(nil) {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// CurrentDatabase returns the database the connection is in, which the
+// server picks if the connection string doesn't name one. For sqlite it's
+// the path of the database file. It's empty if there is none, such as for an
+// in-memory sqlite database.
+func (conn *Connection) CurrentDatabase() (string, error) {
+	var query string
+	switch conn.dbType {
+	case DriverPostgreSQL, DriverDuckDB:
+		query = "SELECT current_database()"
+	case DriverMySQL:
+		query = "SELECT DATABASE()"
+	case DriverOracle:
+		query = "SELECT SYS_CONTEXT('USERENV', 'DB_NAME') FROM dual"
+	case DriverSqlServer:
+		query = "SELECT DB_NAME()"
+	case DriverSnowflake:
+		query = "SELECT CURRENT_DATABASE()"
+	case DriverSQLite:
+		query = "SELECT file FROM pragma_database_list WHERE name = 'main'"
+	default:
+		return "", nil
+	}
+
+	values, err := conn.queryColumn(0, query)
+	if err != nil || len(values) == 0 || values[0] == FormatValue(nil) {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// SetSchema changes the schema (the database, for mysql) that unqualified
+// names resolve to. The connection is reopened so that every connection in
+// the pool uses it, so it can't be done with a transaction open.
+func (conn *Connection) SetSchema(schema string) error {
+	if conn.tx != nil {
+		return fmt.Errorf("commit or roll back the open transaction before changing the schema")
+	}
+	if _, _, err := withSchema(conn.dbType, conn.params[1], nil, schema); err != nil {
+		return err
+	}
+
+	previous := conn.Options.Schema
+	conn.Options.Schema = schema
+	if err := conn.Reconnect(); err != nil {
+		// go back to the schema that worked
+		conn.Options.Schema = previous
+		if reconnectErr := conn.Reconnect(); reconnectErr != nil {
+			return fmt.Errorf("%w; reconnecting to the previous schema also failed: %v", err, reconnectErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// queryColumn runs a query with arguments and returns the values of column
+// col from every row as strings.
+func (conn *Connection) queryColumn(col int, query string, args ...any) ([]string, error) {
+	context, cancelFunc := context.WithTimeout(conn.context, conn.queryTimeout())
+	defer cancelFunc()
+
+	rows, err := conn.querier().QueryContext(context, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result, err := readRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		if col >= len(row) {
+			return nil, fmt.Errorf("query returned %d columns, expected at least %d", len(row), col+1)
+		}
+		switch val := row[col].(type) {
+		case []byte:
+			values = append(values, string(val))
+		default:
+			values = append(values, FormatValue(val))
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+)
+
+// dollarQuotePattern matches a postgres dollar quote tag, e.g. $$ or $body$.
+var dollarQuotePattern = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+
+// SplitStatements splits a script into its statements, which are separated by
+// semicolons outside of comments and quotes. A line starting with a backslash
+// command is a statement of its own. For oracle, a line holding just a slash
+// also ends a statement, and is the only thing that ends a PL/SQL block, since
+// the block is itself full of semicolons. The semicolons that separate
+// statements are left off.
+func SplitStatements(script string, dbType int) []string {
+	var statements []string
+	add := func(statement string) {
+		statement = strings.TrimSpace(statement)
+		if strings.HasPrefix(statement, `\`) || len(keywords(statement)) > 0 {
+			statements = append(statements, statement)
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\\' && len(keywords(script[start:i])) == 0:
+			// a backslash command runs to the end of the line
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			add(script[i : i+end])
+			i += end
+			start = i + 1
+
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			for i < len(script)-1 && script[i+1] != '\n' {
+				i++
+			}
+
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += 2 + end + 1
+			}
+
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(script[i+1:], c)
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += 1 + end
+			}
+
+		case c == '$' && dbType == DriverPostgreSQL:
+			tag := dollarQuotePattern.FindString(script[i:])
+			if tag == "" {
+				continue
+			}
+			end := strings.Index(script[i+len(tag):], tag)
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += len(tag) + end + len(tag) - 1
+			}
+
+		case c == ';':
+			if dbType == DriverOracle && isBlock(script[start:i]) {
+				continue
+			}
+			add(script[start:i])
+			start = i + 1
+
+		case c == '\n' && dbType == DriverOracle:
+			lineStart := strings.LastIndexByte(script[:i], '\n') + 1
+			if lineStart >= start && strings.TrimSpace(script[lineStart:i]) == "/" {
+				add(script[start:lineStart])
+				start = i + 1
+			}
+		}
+	}
+
+	if start < len(script) {
+		rest := script[start:]
+		lineStart := strings.LastIndexByte(rest, '\n') + 1
+		if dbType == DriverOracle && strings.TrimSpace(rest[lineStart:]) == "/" {
+			rest = rest[:lineStart]
+		}
+		add(rest)
+	}
+	return statements
+}
+
+// IsIncomplete reports whether a statement typed at the prompt goes on over
+// more lines, because it leaves a quote, a comment or a parenthesis open.
+func IsIncomplete(statement string, dbType int) bool {
+	depth := 0
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case c == '-' && strings.HasPrefix(statement[i:], "--"):
+			for i < len(statement)-1 && statement[i+1] != '\n' {
+				i++
+			}
+
+		case c == '/' && strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				return true
+			}
+			i += 2 + end + 1
+
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(statement[i+1:], c)
+			if end < 0 {
+				return true
+			}
+			i += 1 + end
+
+		case c == '$' && dbType == DriverPostgreSQL:
+			tag := dollarQuotePattern.FindString(statement[i:])
+			if tag == "" {
+				continue
+			}
+			end := strings.Index(statement[i+len(tag):], tag)
+			if end < 0 {
+				return true
+			}
+			i += len(tag) + end + len(tag) - 1
+
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+// isBlock reports whether an oracle statement is a PL/SQL block, or creates a
+// stored program, which only a slash on its own line ends.
+func isBlock(statement string) bool {
+	words := keywords(statement)
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "BEGIN", "DECLARE":
+		return true
+	case "CREATE":
+		for _, word := range words[1:] {
+			switch word {
+			case "OR", "REPLACE", "EDITIONABLE", "NONEDITIONABLE":
+				continue
+			case "PROCEDURE", "FUNCTION", "PACKAGE", "TRIGGER", "TYPE":
+				return true
+			}
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// notices gathers the notices postgres sends while queries run, until they're
+// taken to be shown with the query's result. Those raised as warnings, like
+// RAISE WARNING or a deprecated feature, are kept apart from the rest, like
+// RAISE NOTICE, which are output in the way of DBMS_OUTPUT. Output beyond
+// limit bytes is dropped, unless limit is zero.
+type notices struct {
+	mu        sync.Mutex
+	limit     int
+	size      int
+	truncated bool
+	output    []string
+	warnings  []string
+}
+
+func (n *notices) add(notice *pq.Error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if notice.Code.Class() == "01" {
+		n.warnings = append(n.warnings, fmt.Sprintf("%s (%s)", notice.Message, notice.Severity))
+		return
+	}
+	if n.limit > 0 && n.size+len(notice.Message) > n.limit {
+		n.truncated = true
+		return
+	}
+	n.size += len(notice.Message)
+	n.output = append(n.output, notice.Message)
+}
+
+// take returns the output and warnings gathered since it was last called,
+// with a note on the end of the output if some of it was dropped.
+func (n *notices) take() (string, []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	output := strings.Join(n.output, "\n")
+	if n.truncated {
+		output += fmt.Sprintf("\n(notices truncated at %d bytes)", n.limit)
+	}
+	warnings := n.warnings
+	n.output, n.warnings, n.size, n.truncated = nil, nil, 0, false
+	return output, warnings
+}
+
+// openPostgres opens a pool of postgres connections that pass the notices
+// they receive to onNotice.
+func openPostgres(dsn string, onNotice func(*pq.Error)) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(pq.ConnectorWithNoticeHandler(connector, onNotice)), nil
+}
+
+// mysqlWarnings reads the warnings left by the last statement run on the
+// session q, such as for truncated values or implicit conversions.
+func mysqlWarnings(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}) ([]string, error) {
+	rows, err := q.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var warnings []string
+	for rows.Next() {
+		var level, message string
+		var code int
+		if err = rows.Scan(&level, &code, &message); err != nil {
+			return warnings, err
+		}
+		warnings = append(warnings, fmt.Sprintf("%s (%s %d)", message, level, code))
+	}
+	return warnings, rows.Err()
+}
@@ -0,0 +1,93 @@
+package querylog
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Logger appends every executed statement to a file, one line per statement
+// with a timestamp and where the statement came from. A nil *Logger discards
+// everything, so callers don't need to check whether logging is enabled.
+type Logger struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open opens (or creates) the log file at path for appending. If maxSize is
+// positive, the file is rotated to path.1 once it grows past maxSize bytes.
+func Open(path string, maxSize int64) (*Logger, error) {
+	logger := &Logger{path: path, maxSize: maxSize}
+	if err := logger.open(); err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
+
+func (logger *Logger) open() error {
+	file, err := os.OpenFile(logger.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open query log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open query log: %w", err)
+	}
+	logger.file = file
+	logger.size = info.Size()
+	return nil
+}
+
+// Log records that query was executed on behalf of source, which is the
+// client address in server mode.
+func (logger *Logger) Log(source, query string) {
+	if logger == nil {
+		return
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	if logger.maxSize > 0 && logger.size >= logger.maxSize {
+		logger.rotate()
+	}
+	if logger.file == nil {
+		return
+	}
+
+	line := fmt.Sprintf("%s\t%s\t%q\n", time.Now().Format(time.RFC3339Nano), source, query)
+	n, _ := logger.file.WriteString(line)
+	logger.size += int64(n)
+}
+
+// rotate moves the current file aside to path.1 and starts a new one.
+func (logger *Logger) rotate() {
+	logger.file.Close()
+	logger.file = nil
+	if err := os.Rename(logger.path, logger.path+".1"); err != nil {
+		log.Printf("Error rotating query log: %v", err)
+	}
+	if err := logger.open(); err != nil {
+		log.Printf("Error rotating query log: %v", err)
+	}
+}
+
+// Close closes the log file.
+func (logger *Logger) Close() error {
+	if logger == nil {
+		return nil
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if logger.file == nil {
+		return nil
+	}
+	return logger.file.Close()
+}
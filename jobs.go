@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqlrepl/internal/protocol"
+)
+
+// job is a query running in the background, started with \async or by ending
+// the query with &.
+type job struct {
+	id      int
+	query   string
+	started time.Time
+	cancel  context.CancelFunc
+
+	// done is closed once the query has finished, after which result and
+	// elapsed are set
+	done     chan struct{}
+	result   *protocol.QueryResult
+	elapsed  time.Duration
+	reported bool
+}
+
+// finished reports whether the job's query is done, without waiting for it.
+func (j *job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// async runs a query in the background, leaving the prompt free. Its result is
+// printed by \wait. Usage: \async <query>
+func (r *repl) async(args string) error {
+	if args == "" {
+		return fmt.Errorf("usage: \\async <query>")
+	}
+	if strings.HasPrefix(args, localPrefix) {
+		return fmt.Errorf("local queries can't be run in the background")
+	}
+
+	// a background query can't join the transaction, which is tied to one
+	// session, so it would see and change data outside it
+	if r.conn.InTransaction() {
+		return fmt.Errorf("can't run a query in the background inside a transaction")
+	}
+	if !r.conn.Autocommit() {
+		return fmt.Errorf("can't run a query in the background with autocommit off")
+	}
+
+	query := substitute(args, r.vars)
+	if !r.allowed(r.conn, query) {
+		fmt.Println("Statement not run.")
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.nextJob++
+	j := &job{
+		id:      r.nextJob,
+		query:   query,
+		started: time.Now(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	if r.jobs == nil {
+		r.jobs = map[int]*job{}
+	}
+	r.jobs[j.id] = j
+
+	// the job gets a connection of its own, so that it shares nothing with
+	// the queries run at the prompt meanwhile
+	conn := r.conn.Sibling()
+	go func() {
+		defer close(j.done)
+		j.result = conn.ExecuteQueryContext(ctx, query)
+		j.elapsed = time.Since(j.started)
+		if ctx.Err() == context.Canceled {
+			j.result.Error = "Query cancelled"
+		}
+	}()
+
+	fmt.Printf("[%d] started\n", j.id)
+	return nil
+}
+
+// listJobs lists the background queries that haven't been waited for.
+// Usage: \jobs
+func (r *repl) listJobs(args string) error {
+	if len(r.jobs) == 0 {
+		fmt.Println("No background queries.")
+		return nil
+	}
+
+	list := &protocol.QueryResult{Columns: []string{"id", "status", "elapsed", "query"}}
+	for _, id := range r.jobIDs() {
+		j := r.jobs[id]
+		status, elapsed := "running", time.Since(j.started)
+		if j.finished() {
+			status, elapsed = "done", j.elapsed
+			if j.result.Error != "" {
+				status = "failed"
+			}
+			j.reported = true
+		}
+		list.Rows = append(list.Rows, &protocol.Row{Values: []string{
+			strconv.Itoa(id), status, elapsed.Round(time.Millisecond).String(), j.query,
+		}})
+	}
+	r.printQueryResult(list)
+	return nil
+}
+
+// wait waits for a background query to finish and prints its result.
+// Usage: \wait <id>
+func (r *repl) wait(args string) error {
+	id, err := strconv.Atoi(args)
+	if err != nil {
+		return fmt.Errorf("usage: \\wait <id>")
+	}
+	j, ok := r.jobs[id]
+	if !ok {
+		return fmt.Errorf("no background query %d", id)
+	}
+
+	<-j.done
+	j.cancel()
+	delete(r.jobs, id)
+	r.lastResult = j.result
+	r.printQueryResult(j.result)
+	fmt.Printf("[%d] finished in %v\n", id, j.elapsed.Round(time.Millisecond))
+	return nil
+}
+
+// reportJobs tells the user about background queries that have finished since
+// they were last told, so they know to \wait for them.
+func (r *repl) reportJobs() {
+	for _, id := range r.jobIDs() {
+		j := r.jobs[id]
+		if !j.reported && j.finished() {
+			j.reported = true
+			fmt.Printf("[%d] done, \\wait %d for its result\n", id, id)
+		}
+	}
+}
+
+// cancelJobs stops any background queries still running.
+func (r *repl) cancelJobs() {
+	for _, j := range r.jobs {
+		j.cancel()
+	}
+}
+
+func (r *repl) jobIDs() []int {
+	ids := make([]int, 0, len(r.jobs))
+	for id := range r.jobs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -2,42 +2,138 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
 
 	"sqlrepl/internal/client"
 	"sqlrepl/internal/database"
 	"sqlrepl/internal/protocol"
+	"sqlrepl/internal/querylog"
 )
 
 const (
 	defaultListenAddress = 8080
+	defaultFetchSize     = 1000
+	defaultBorder        = 1
+	defaultConnStringEnv = "DATABASE_URL"
+	defaultFormat        = "table"
+	defaultSeqScanRows   = 100000
+	defaultMaxErrors     = 3
+	defaultInsertTable   = "result"
+	defaultMaxOutputSize = 1 << 20
+	defaultDrainTimeout  = 30 * time.Second
+
+	// drainReportInterval is how often the server logs the queries it's
+	// waiting for while shutting down
+	drainReportInterval = 5 * time.Second
 )
 
 var (
 	// Flags
-	dbType        = flag.String("t", "", "Database type (oracle, mysql, postgres, sqlite3)")
-	dbConnString  = flag.String("c", "", "Database connection string")
-	listenAddress = flag.Int("p", defaultListenAddress, "Address to listen on in server mode")
+	dbType         = flag.String("t", "", "Database type (oracle, mysql, postgres, sqlite3, sqlserver, snowflake, duckdb)")
+	dbConnString   = flag.String("c", "", "Database connection string, or env:VAR to read it from an environment variable (default $DATABASE_URL)")
+	buildDSN       = flag.String("build-dsn", "", "Ask for the host, port, user, password and database of this database type, print its connection string, and exit")
+	listenAddress  = flag.Int("p", defaultListenAddress, "Address to listen on in server mode")
+	unixSocket     = flag.String("unix", "", "Listen on this UNIX domain socket instead of TCP in server mode")
+	border         = flag.Int("border", defaultBorder, "Table border style: 0 (none), 1 (header separator), 2 (full grid)")
+	safeMode       = flag.Bool("safe", false, "Require confirmation for (or in server mode, reject) destructive statements")
+	queryLogPath   = flag.String("query-log", "", "File to append every executed statement to")
+	recordFile     = flag.String("record", "", "File to append every query run, and its result, to for -replay to run again")
+	recordQueries  = flag.Bool("record-queries-only", false, "Leave the results out of the -record file")
+	replayFile     = flag.String("replay", "", "Run the queries in a -record file, warning of any whose result differs from the recording, and exit")
+	queryLogSize   = flag.Int64("query-log-max-size", 0, "Rotate the query log after this many bytes (0 to never rotate)")
+	outputFormat   = flag.String("o", defaultFormat, "Output format (table, csv, tsv, json, xml, html, insert, vertical, fixed, yaml)")
+	trimWhitespace = flag.Bool("trim", false, "Trim trailing whitespace and show newlines as ↵ in table output")
+	tuplesOnly     = flag.Bool("tuples-only", false, "Print single-column results as bare values, one per line, without a header")
+	flushPolicy    = flag.String("flush", flushRow, "When printed results are flushed: row, after each line, or result, once the whole result is written")
+	promptFormat   = flag.String("prompt", "", "Prompt template, with {driver}, {conn}, {db}, {tx} and {time} filled in, e.g. '{driver}:{db}{tx}> '")
+	numberLocale   = flag.String("locale", "", "Show numbers in the table, vertical and html formats with this locale's digit grouping and decimal point, e.g. en-US or de-DE")
+	jsonTyped      = flag.Bool("json-typed", false, "Write numbers and booleans in the json output format as JSON numbers and booleans rather than strings")
+	insertTable    = flag.String("insert-table", defaultInsertTable, "Table name used by the insert output format")
+	warnSeqScan    = flag.Bool("warn-seqscan", false, "EXPLAIN queries first and confirm before running ones with large sequential scans or cartesian joins (postgres)")
+	seqScanRows    = flag.Int("seqscan-rows", defaultSeqScanRows, "Estimated row count above which -warn-seqscan warns about a sequential scan")
+	confirmRows    = flag.Int("confirm-rows", 0, "Count the rows an UPDATE or DELETE would change first and confirm before changing more than this many (0 to never)")
+	queryTimeout   = flag.Duration("query-timeout", database.DefaultQueryTimeout, "Stop queries that run for longer than this; \\timeout-once overrides it for one statement")
+	idleTimeout    = flag.Duration("idle-timeout", 0, "Exit interactive mode after this long without input (0 to never)")
+	healthPort     = flag.Int("health-port", 0, "Serve /healthz and /readyz on this port in server mode (0 to disable)")
+	healthDBType   = flag.String("health-db-type", "", "Database type pinged by /readyz")
+	healthConn     = flag.String("health-conn", "", "Connection string of the database pinged by /readyz")
+	metricsPort    = flag.Int("metrics-port", 0, "Serve Prometheus metrics on /metrics at this port in server mode (0 to disable)")
+	queryFilter    = flag.String("query-filter", "", "JSON file of allow and deny regular expressions that queries must pass in server mode, reread on SIGHUP")
+	drainTimeout   = flag.Duration("drain-timeout", defaultDrainTimeout, "On shutdown in server mode, wait this long for the queries running to finish (0 to not wait)")
+	sharedPools    = flag.Bool("shared-pool", false, "Let clients connecting with the same parameters share one connection pool in server mode")
+	maxQPS         = flag.Float64("max-qps", 0, "Maximum queries per second for each client in server mode (0 for no limit)")
+	clientEncoding = flag.String("client-encoding", "", "Character set text is exchanged with the database in, e.g. latin1 (mysql, oracle; postgres only allows UTF8)")
+	readOnly       = flag.Bool("readonly", false, "Open a read-only session and reject statements that write (sql server and snowflake sessions aren't read-only, so only the rejection guards them)")
+	maxErrors      = flag.Int("max-errors", defaultMaxErrors, "Offer to reconnect after this many consecutive identical errors (0 to never)")
+	scriptFile     = flag.String("f", "", "Run the statements in this file, or - for stdin, and exit (the default when stdin isn't a terminal)")
+	varsFile       = flag.String("vars", "", "JSON file of variables to substitute for :name in queries")
+	queriesFile    = flag.String("queries", "", "JSON file that \\save keeps named queries in (default ~/.sqlrepl_queries.json)")
+	pageSize       = flag.Int("page-size", 0, "Print the rows of queries at the prompt this many at a time, asking before fetching the next page (0 to print them all)")
+	maxFieldSize   = flag.Int("max-field-size", 0, "Warn about values this many bytes or longer, which the driver may have truncated (0 to never)")
+	maxOutputSize  = flag.Int("max-output-size", defaultMaxOutputSize, "Bytes of DBMS_OUTPUT or notices kept with each result, the rest is dropped (0 to keep it all, oracle, postgres)")
+	noDbmsOutput   = flag.Bool("no-dbms-output", false, "Don't enable or read DBMS_OUTPUT after each query (oracle)")
+	fetchSize      = flag.Int("fetch-size", defaultFetchSize, "Number of rows to fetch per round trip (oracle)")
+	connectAddr    = flag.String("connect", "", "Run interactive mode through the sqlrepl server at host:port, or unix:/path for a UNIX socket")
+	noPing         = flag.Bool("no-ping", false, "Don't check that the database can be reached when connecting; any problem is reported by the first query")
+	waitForDB      = flag.Duration("wait-for-db", 0, "Keep trying to reach a database that isn't answering yet, such as one starting up, for this long (0 to try once)")
+	retryAttempts  = flag.Int("retries", client.DefaultRetryPolicy.Attempts, "Times to try reconnecting to the -connect server before giving up (0 to never)")
+	retryDelay     = flag.Duration("retry-delay", client.DefaultRetryPolicy.Delay, "Wait before the first reconnection to the -connect server, doubling on each attempt")
 )
 
+// driverOptions and connVars collect the repeatable -opt and -var flags
+var (
+	driverOptions = optionFlags{}
+	connVars      = optionFlags{}
+)
+
+func init() {
+	flag.Var(driverOptions, "opt", "Driver option as key=value, merged into the connection string (repeatable)")
+	flag.Var(connVars, "var", "Value for a {{name}} placeholder in the connection string, as name=value (repeatable)")
+}
+
 func main() {
 	flag.Parse()
 	args := flag.Args()
 
+	if *buildDSN != "" {
+		os.Exit(runBuildDSN(*buildDSN))
+	}
+
+	// go through a server instead of connecting to the database directly
+	run := runInteractive
+	if *connectAddr != "" {
+		run = runRemote
+	}
+
 	// Check for positional arguments for interactive mode
 	if len(args) == 2 {
-		runInteractive(args[0], args[1])
-		return
+		os.Exit(run(args[0], args[1]))
 	}
 
-	// Use flags if provided
-	if *dbType != "" && *dbConnString != "" {
-		runInteractive(*dbType, *dbConnString)
-		return
+	// Use flags if provided, falling back on $DATABASE_URL for the
+	// connection string
+	connString := *dbConnString
+	if connString == "" {
+		connString = os.Getenv(defaultConnStringEnv)
+	}
+	if *dbType != "" && connString != "" {
+		os.Exit(run(*dbType, connString))
 	}
 
 	// Run in server mode if no flags are provided
@@ -49,88 +145,723 @@ func main() {
 	// Otherwise, print usage
 	fmt.Println("Usage:")
 	fmt.Println("  sqlrepl <dbtype> <connstring>  (Interactive mode)")
+	fmt.Println("  sqlrepl -f <file> <dbtype> <connstring>  (Run a script)")
+	fmt.Println("  sqlrepl -connect <host:port> <dbtype> <connstring>  (Interactive mode through a server)")
 	fmt.Println("  sqlrepl -p <port>               (Server mode)")
+	fmt.Println("  sqlrepl -unix <path>            (Server mode on a UNIX socket)")
+	fmt.Println("  sqlrepl -build-dsn <dbtype>     (Build a connection string)")
 	flag.PrintDefaults()
 	os.Exit(1)
 }
 
-func runInteractive(dbType, dbConnString string) {
-	dbconn := database.Connection{}
+// runBuildDSN asks for the parts of a connection string for dbType and prints
+// the connection string, returning the exit status. The questions go to
+// stderr so that the answer can be captured, as in
+// DATABASE_URL=$(sqlrepl -build-dsn postgres).
+func runBuildDSN(dbType string) int {
+	driver, err := database.ValidateDBType(dbType)
+	if err != nil {
+		log.Printf("Error building connection string: %v", err)
+		return 1
+	}
+
+	input := bufio.NewReader(os.Stdin)
+	ask := func(question, fallback string) string {
+		if fallback != "" {
+			question += fmt.Sprintf(" [%s]", fallback)
+		}
+		fmt.Fprint(os.Stderr, question+": ")
+		answer, _ := input.ReadString('\n')
+		if answer = strings.TrimSpace(answer); answer == "" {
+			return fallback
+		}
+		return answer
+	}
+	askPassword := func() string {
+		// don't echo the password when it's typed
+		if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+			fmt.Fprint(os.Stderr, "Password: ")
+			password, _ := term.ReadPassword(fd)
+			fmt.Fprintln(os.Stderr)
+			return string(password)
+		}
+		return ask("Password", "")
+	}
+
+	var parts database.DSNParts
+	switch driver {
+	case database.DriverSQLite:
+		parts.Database = ask("Database file", "")
+	case database.DriverDuckDB:
+		parts.Database = ask("Database file", ":memory:")
+	case database.DriverSnowflake:
+		parts.Host = ask("Account", "")
+		parts.User = ask("User", "")
+		parts.Password = askPassword()
+		parts.Database = ask("Database", "")
+	default:
+		parts.Host = ask("Host", "localhost")
+		parts.Port = ask("Port", database.DefaultPorts[driver])
+		parts.User = ask("User", "")
+		parts.Password = askPassword()
+		if driver == database.DriverOracle {
+			parts.Database = ask("Service name", "")
+		} else {
+			parts.Database = ask("Database", "")
+		}
+	}
+
+	dsn, err := database.BuildDSN(driver, parts)
+	if err != nil {
+		log.Printf("Error building connection string: %v", err)
+		return 1
+	}
+	fmt.Println(dsn)
+	return 0
+}
+
+// runInteractive runs a session on the database, reading statements from the
+// prompt, or from a script when there is one, and returns the exit status.
+func runInteractive(dbType, dbConnString string) int {
+	dbConnString = prepareConnString(dbConnString)
+
+	dbconn := database.Connection{Options: connectionOptions()}
 	err := dbconn.Connect(dbType, dbConnString)
 	if err != nil {
 		log.Fatalf("Error connecting to database: %v", err)
 	}
-	defer dbconn.Close()
+	// the session closes the connection when it ends
+	r := newRepl(&dbconn, bufio.NewScanner(os.Stdin))
+	return r.run()
+}
+
+// runRemote runs a session like runInteractive, but has the sqlrepl server at
+// -connect open the database and run the statements.
+func runRemote(dbType, dbConnString string) int {
+	dbConnString = prepareConnString(dbConnString)
+
+	driver, err := database.ValidateDBType(dbType)
+	if err != nil {
+		log.Fatalf("Error connecting to server: %v", err)
+	}
+
+	network, address := "tcp", *connectAddr
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, address = "unix", path
+	}
+	retry := client.DefaultRetryPolicy
+	retry.Attempts = *retryAttempts
+	retry.Delay = *retryDelay
+
+	params := &protocol.DBParams{Dbtype: dbType, Connstring: dbConnString}
+	session, err := client.Dial(network, address, params, retry)
+	if err != nil {
+		log.Fatalf("Error connecting to server: %v", err)
+	}
+	r := newRepl(nil, bufio.NewScanner(os.Stdin))
+	r.remote = session
+	r.remoteType = driver
+	return r.run()
+}
+
+// localePrinter returns the printer for numbers in locale, or nil if none is
+// given.
+func localePrinter(locale string) *message.Printer {
+	tag, err := language.Parse(locale)
+	if locale == "" || err != nil {
+		return nil
+	}
+	return message.NewPrinter(tag)
+}
+
+// prepareConnString checks the output options, which is better done before
+// connecting, and fills in the connection string from the environment and
+// -var flags.
+func prepareConnString(connString string) string {
+	if _, ok := formatters[*outputFormat]; !ok {
+		log.Fatalf("Unknown output format: %s", *outputFormat)
+	}
+	if *flushPolicy != flushRow && *flushPolicy != flushResult {
+		log.Fatalf("Unknown flush policy: %s", *flushPolicy)
+	}
+	if _, err := language.Parse(*numberLocale); *numberLocale != "" && err != nil {
+		log.Fatalf("Unknown locale: %s", *numberLocale)
+	}
+
+	connString, err := resolveConnString(connString)
+	if err != nil {
+		log.Fatalf("Error reading connection string: %v", err)
+	}
+	connString, err = renderConnString(connString, connVars)
+	if err != nil {
+		log.Fatalf("Error reading connection string: %v", err)
+	}
+	return connString
+}
 
-	fmt.Println("Connected. Enter SQL queries (or 'exit' to quit):")
-	scanner := bufio.NewScanner(os.Stdin)
+// run reads statements from the prompt, or runs the script when there is
+// one, and returns the exit status.
+func (r *repl) run() int {
+	queryLog := openQueryLog()
+	defer queryLog.Close()
+	r.queryLog = queryLog
+	for _, conn := range r.conns {
+		r.logQueries(conn)
+	}
+	recorder := openRecorder()
+	defer recorder.Close()
+	r.recorder = recorder
+	defer r.close()
 
+	var err error
+	if *varsFile != "" {
+		if r.vars, err = loadVars(*varsFile); err != nil {
+			log.Fatalf("Error loading variables: %v", err)
+		}
+	}
+
+	if *replayFile != "" {
+		status := 0
+		if !r.replay(*replayFile) {
+			status = 1
+		}
+		r.endTransaction()
+		return status
+	}
+
+	script, isScript, err := readScript()
+	if err != nil {
+		log.Fatalf("Error reading script: %v", err)
+	}
+	if isScript {
+		status := 0
+		if !r.runScript(script) {
+			status = 1
+		}
+		r.endTransaction()
+		return status
+	}
+
+	fmt.Println(r.banner())
+
+	// exit cleanly if nobody types anything for a while. The timer only runs
+	// while waiting at the prompt, never while a query is running.
+	var idleTimer *time.Timer
+	if *idleTimeout > 0 {
+		idleTimer = time.AfterFunc(*idleTimeout, func() {
+			fmt.Printf("\nNo input for %v, closing the connection.\n", *idleTimeout)
+			r.close()
+			queryLog.Close()
+			os.Exit(0)
+		})
+		idleTimer.Stop()
+	}
+
+	scanner := r.input
 	for {
-		fmt.Print("> ")
-		if !scanner.Scan() {
+		r.reportJobs()
+		if len(r.buffer) > 0 {
+			fmt.Print(continuationPrompt(r.prompt()))
+		} else {
+			fmt.Print(r.prompt())
+		}
+		if idleTimer != nil {
+			idleTimer.Reset(*idleTimeout)
+		}
+		scanned := scanner.Scan()
+		if idleTimer != nil && !idleTimer.Stop() {
+			select {} // the timer fired first and is shutting down
+		}
+		if !scanned {
 			break // Exit on Ctrl+D
 		}
 		query := scanner.Text()
-		if query == "exit" {
+		if query == "exit" && len(r.buffer) == 0 {
 			break
 		}
 
-		result := dbconn.ExecuteQuery(query)
+		if strings.HasPrefix(query, `\`) {
+			if err := r.runCommand(query); err != nil {
+				fmt.Println("Error:", err)
+			}
+			continue
+		}
+
+		// a statement that leaves a quote, comment or parenthesis open is
+		// gathered over the lines that follow, until it's closed or \abort
+		// discards it
+		r.buffer = append(r.buffer, query)
+		query = strings.Join(r.buffer, "\n")
+		if database.IsIncomplete(query, r.dbType()) {
+			continue
+		}
+		r.buffer = nil
 
-		if result == nil {
-			log.Printf("Result returned from executeQuery was nil: %v", err)
-			return
+		// a query ending in & runs in the background, like \async
+		if background, ok := strings.CutSuffix(strings.TrimSpace(query), "&"); ok {
+			if err := r.runCommand(`\async ` + strings.TrimSpace(background)); err != nil {
+				fmt.Println("Error:", err)
+			}
+			continue
 		}
 
-		printQueryResult(result) // Helper function to format and print result
+		// several statements on one line are run one after the other; a
+		// single one is sent as typed
+		statements := database.SplitStatements(query, r.dbType())
+		if len(statements) <= 1 {
+			statements = []string{query}
+		}
+		if !r.runStatements(statements) {
+			break
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
 		log.Println("Error reading input:", err)
 	}
+
+	r.endTransaction()
+	return 0
+}
+
+// banner is the line printed when the session starts, naming the database
+// and schema it's in, which the server picks when the connection string
+// doesn't say.
+func (r *repl) banner() string {
+	database := ""
+	if r.conn != nil && !r.conn.Options.NoPing {
+		// the banner just goes without the database if it can't be found,
+		// and with -no-ping isn't worth waiting on a database that may
+		// not be reachable
+		database, _ = r.conn.CurrentDatabase()
+	}
+
+	where := ""
+	if database != "" {
+		where = " to " + database
+	}
+	if r.schema != "" && r.schema != database {
+		where += fmt.Sprintf(" (schema %s)", r.schema)
+	}
+	return fmt.Sprintf("Connected%s. Enter SQL queries (or 'exit' to quit):", where)
+}
+
+// runStatements runs statements entered at the prompt in turn. It reports
+// false if the user chose to exit after they kept failing.
+func (r *repl) runStatements(statements []string) bool {
+	for _, statement := range statements {
+		result := r.runQuery(statement)
+		if result != nil && r.repeatedFailure(result) && !r.recoverSession() {
+			return false
+		}
+	}
+	return true
+}
+
+// readScript reads the script named by -f, or all of stdin if it isn't a
+// terminal, reporting false if there's no script and statements should be
+// read from the prompt.
+func readScript() (string, bool, error) {
+	if *scriptFile != "" && *scriptFile != "-" {
+		script, err := os.ReadFile(*scriptFile)
+		return string(script), true, err
+	}
+
+	if *scriptFile == "" {
+		info, err := os.Stdin.Stat()
+		if err != nil || info.Mode()&os.ModeCharDevice != 0 {
+			return "", false, nil
+		}
+	}
+	script, err := io.ReadAll(os.Stdin)
+	return string(script), true, err
+}
+
+// runScript runs each statement of a script in turn and reports whether they
+// all succeeded. A failed statement doesn't stop the rest from running.
+func (r *repl) runScript(script string) bool {
+	ok := true
+	for _, statement := range database.SplitStatements(script, r.dbType()) {
+		if statement == "exit" {
+			break
+		}
+		if strings.HasPrefix(statement, `\`) {
+			if err := r.runCommand(statement); err != nil {
+				fmt.Println("Error:", err)
+				ok = false
+			}
+			continue
+		}
+		if result := r.runQuery(statement); result == nil || result.Error != "" {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// continuationPrompt is shown in place of prompt while a statement is being
+// typed over several lines.
+func continuationPrompt(prompt string) string {
+	indent := max(utf8.RuneCountInString(prompt)-len("-> "), 0)
+	return strings.Repeat(" ", indent) + "-> "
 }
 
 func runServer(listenAddress int) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", listenAddress))
+	network, address := "tcp", fmt.Sprintf(":%d", listenAddress)
+	if *unixSocket != "" {
+		network, address = "unix", *unixSocket
+	}
+
+	listener, err := net.Listen(network, address)
 	if err != nil {
 		log.Fatalf("Error listening: %v", err)
 	}
 	defer listener.Close()
 
-	fmt.Printf("SQL REPL server listening on %d\n", listenAddress)
+	// closing the listener on interrupt lets the deferred cleanup run, which
+	// for a unix socket removes the socket file, once the queries running
+	// have finished; a second interrupt stops waiting for them
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	fmt.Printf("SQL REPL server listening on %s\n", address)
+
+	if *healthPort != 0 {
+		go runHealthServer(*healthPort)
+	}
+	if *metricsPort != 0 {
+		go runMetricsServer(*metricsPort)
+	}
+
+	config := serverConfig()
+	config.QueryLog = openQueryLog()
+	defer config.QueryLog.Close()
+
+	if config.Filter, err = loadQueryFilter(); err != nil {
+		log.Fatalf("Error loading query filter: %v", err)
+	}
+	holder := client.NewConfigHolder(config)
+
+	// on SIGHUP the files the config is read from are read again, for the
+	// connections accepted after that
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for range reloads {
+			reloadServerConfig(holder)
+		}
+	}()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				log.Println("Server shutting down")
+				drainQueries(signals, *drainTimeout)
+				return
+			}
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
 		log.Printf("Accepted connection from %s\n", conn.RemoteAddr().String())
-		go client.Handle(conn) // Delegate to client handler (modified)
+		go client.Handle(conn, holder.Load()) // Delegate to client handler (modified)
 	}
 }
 
-func printQueryResult(result *protocol.QueryResult) {
-	if result.Error != "" {
-		fmt.Println("Error:", result.Error)
+// drainQueries waits for the queries clients are running to finish, turning
+// away any new ones, for up to timeout or until another signal arrives. While
+// it waits it logs which clients it's waiting for.
+func drainQueries(signals <-chan os.Signal, timeout time.Duration) {
+	client.Drain()
+	if len(client.Running()) == 0 || timeout <= 0 {
 		return
 	}
 
-	if len(result.Columns) > 0 {
-		for _, col := range result.Columns {
-			fmt.Printf("%s\t", col)
+	deadline := time.After(timeout)
+	report := time.NewTicker(drainReportInterval)
+	defer report.Stop()
+	check := time.NewTicker(100 * time.Millisecond)
+	defer check.Stop()
+
+	logRunningQueries()
+	for {
+		select {
+		case <-check.C:
+			if len(client.Running()) == 0 {
+				log.Println("All queries finished")
+				return
+			}
+		case <-report.C:
+			logRunningQueries()
+		case <-deadline:
+			log.Printf("Gave up waiting for %d queries after %v", len(client.Running()), timeout)
+			return
+		case <-signals:
+			log.Printf("Stopped waiting for %d queries", len(client.Running()))
+			return
+		}
+	}
+}
+
+// logRunningQueries logs how many queries are running and for which clients.
+func logRunningQueries() {
+	queries := client.Running()
+	clients := make([]string, len(queries))
+	for i, query := range queries {
+		clients[i] = fmt.Sprintf("%s (%v)", query.Source, time.Since(query.Started).Round(time.Second))
+	}
+	log.Printf("Waiting for %d queries to finish, interrupt again to stop waiting: %s",
+		len(queries), strings.Join(clients, ", "))
+}
+
+// resolveConnString reads the connection string from the environment when it's
+// given as env:VAR, so that it doesn't have to appear on the command line.
+func resolveConnString(connString string) (string, error) {
+	name, ok := strings.CutPrefix(connString, "env:")
+	if !ok {
+		return connString, nil
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// placeholderPattern matches a {{name}} placeholder in a connection string.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// renderConnString fills in the {{name}} placeholders in a connection string
+// from the -var flags, e.g. postgres://user@host/{{db}} with -var db=analytics.
+func renderConnString(connString string, vars map[string]string) (string, error) {
+	var missing []string
+	rendered := placeholderPattern.ReplaceAllStringFunc(connString, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		value, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no -var given for %s", strings.Join(missing, ", "))
+	}
+	return rendered, nil
+}
+
+// optionFlags collects repeated -opt key=value flags.
+type optionFlags map[string]string
+
+func (options optionFlags) String() string {
+	pairs := make([]string, 0, len(options))
+	for key, value := range options {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (options optionFlags) Set(pair string) error {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", pair)
+	}
+	options[key] = value
+	return nil
+}
+
+// connectionOptions builds the database options from the command-line flags.
+func connectionOptions() database.Options {
+	return database.Options{
+		FetchSize:      *fetchSize,
+		DriverOptions:  driverOptions,
+		ReadOnly:       *readOnly,
+		MaxFieldSize:   *maxFieldSize,
+		MaxOutputSize:  *maxOutputSize,
+		NoDbmsOutput:   *noDbmsOutput,
+		QueryTimeout:   *queryTimeout,
+		WaitForDB:      *waitForDB,
+		NoPing:         *noPing,
+		ClientEncoding: *clientEncoding,
+	}
+}
+
+// openQueryLog opens the query log named by -query-log, returning nil (which
+// logs nothing) if it isn't set.
+func openQueryLog() *querylog.Logger {
+	if *queryLogPath == "" {
+		return nil
+	}
+	queryLog, err := querylog.Open(*queryLogPath, *queryLogSize)
+	if err != nil {
+		log.Fatalf("Error opening query log: %v", err)
+	}
+	return queryLog
+}
+
+// serverConfig builds the client handler configuration from the command-line
+// flags.
+func serverConfig() client.Config {
+	config := client.Config{
+		Options: connectionOptions(),
+		Safe:    *safeMode,
+
+		MaxQueriesPerSecond: *maxQPS,
+	}
+	if *sharedPools {
+		config.Options.Pools = database.NewPools()
+	}
+	return config
+}
+
+// loadQueryFilter reads the -query-filter file, if there is one.
+func loadQueryFilter() (*client.QueryFilter, error) {
+	if *queryFilter == "" {
+		return nil, nil
+	}
+	return client.LoadQueryFilter(*queryFilter)
+}
+
+// reloadServerConfig reads the query filter again and has new connections use
+// it. If it can't be read, the config in use is kept.
+func reloadServerConfig(holder *client.ConfigHolder) {
+	config := holder.Load()
+	filter, err := loadQueryFilter()
+	if err != nil {
+		log.Printf("Error reloading config, keeping the current one: %v", err)
+		return
+	}
+	config.Filter = filter
+	holder.Store(config)
+	log.Println("Reloaded config for new connections")
+}
+
+// promptTokens are the {token}s a prompt template may use, see renderPrompt.
+var promptTokens = regexp.MustCompile(`\{(\w+)\}`)
+
+// renderPrompt fills in a prompt template: {driver} is the database type,
+// {conn} the name of the current connection, {db} the current schema, {tx} a *
+// while a transaction is open, and {time} the time of day. Other tokens are
+// left as they are.
+func (r *repl) renderPrompt(template string) string {
+	return promptTokens.ReplaceAllStringFunc(template, func(token string) string {
+		switch token[1 : len(token)-1] {
+		case "driver":
+			return database.DBTypeString(r.dbType())
+		case "conn":
+			return r.connName
+		case "db":
+			return r.schema
+		case "tx":
+			if r.conn != nil && r.conn.InTransaction() {
+				return "*"
+			}
+			return ""
+		case "time":
+			return time.Now().Format("15:04:05")
 		}
-		fmt.Println()
+		return token
+	})
+}
+
+// runQuery substitutes variables into a query, checks it, runs it, and prints
+// the result. It returns nil if the user decided not to run it.
+func (r *repl) runQuery(query string) *protocol.QueryResult {
+	return r.runQueryTimeout(query, 0)
+}
+
+// runQueryTimeout is runQuery with the query stopped after timeout rather
+// than the usual limit, unless timeout is 0.
+func (r *repl) runQueryTimeout(query string, timeout time.Duration) *protocol.QueryResult {
+	query = substitute(query, r.vars)
+	recorded := query
+	conn, query := r.connectionFor(query)
+
+	if !r.allowed(conn, query) {
+		fmt.Println("Statement not run.")
+		return nil
+	}
+
+	if *warnSeqScan && conn != nil && conn.DBType() == database.DriverPostgreSQL &&
+		database.IsExplainable(query) && !r.checkPlan(conn, query) {
+		fmt.Println("Statement not run.")
+		return nil
+	}
+
+	if timeout == 0 && r.pageable(conn, query) {
+		// the pages are printed as they're read
+		result := r.runPaged(conn, query)
+		r.lastResult = result
+		r.recorder.record(recorded, result)
+		return result
 	}
 
-	for _, row := range result.Rows {
-		for i := range result.Columns {
-			fmt.Printf("%v\t", row.Values[i])
+	var result *protocol.QueryResult
+	if timeout > 0 {
+		r.lastConn = conn
+		result = conn.ExecuteQueryWithTimeout(query, timeout)
+	} else {
+		result = r.executeUnchecked(conn, query)
+	}
+
+	r.lastResult = result
+	r.recorder.record(recorded, result)
+	r.printQueryResult(result) // Helper function to format and print result
+	return result
+}
+
+func (r *repl) printQueryResult(result *protocol.QueryResult) {
+	r.printResult(result, nil)
+}
+
+// printResult prints result like printQueryResult, but has table, when given,
+// write it in the table format. table is passed the result as it's shown,
+// once settings like \fmt and \pset displaylimit have been applied.
+func (r *repl) printResult(result *protocol.QueryResult, table func(w io.Writer, display *protocol.QueryResult)) {
+	if len(result.Columns) > 0 {
+		display := limitRows(result, r.displayLimit)
+		if r.numbers != nil && displayFormats[r.format] {
+			display = localizeNumbers(display, r.numbers, r.columnFormats)
+		}
+		display = reorderColumns(formatColumns(display, r.columnFormats), r.columnOrder)
+		if r.trim && r.format == "table" {
+			display = trimValues(display)
+		}
+		out := newFlushWriter(r.output, *flushPolicy)
+		if r.tuplesOnly && r.format == "table" && len(display.Columns) == 1 {
+			writeValues(out, display)
+		} else if table != nil && r.format == "table" {
+			table(out, display)
+		} else if err := formatters[r.format](r, out, display); err != nil {
+			fmt.Println("Error:", err)
+		}
+		if err := out.Flush(); err != nil {
+			fmt.Println("Error:", err)
+		}
+		if len(display.Rows) < len(result.Rows) {
+			fmt.Printf("(showing %d of %d rows)\n", len(display.Rows), len(result.Rows))
 		}
-		fmt.Println()
 	}
 
 	if result.Message != "" {
 		fmt.Println(result.Message)
 	}
+	for _, warning := range result.Warnings {
+		fmt.Println(highlightWarning("Warning: " + warning))
+	}
+	if r.timingBreakdown && result.ExecuteMicros+result.FetchMicros > 0 {
+		writeTiming(os.Stdout, result)
+	}
+
+	// rows read before an error are still printed so that it's clear where
+	// the query broke
+	if result.Error != "" {
+		fmt.Println("Error:", result.Error)
+		if result.ErrorPosition > 0 && r.lastConn != nil {
+			writeErrorPosition(os.Stdout, r.lastConn.LastQuery(), int(result.ErrorPosition))
+		}
+	}
 }
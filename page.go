@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"sqlrepl/internal/database"
+	"sqlrepl/internal/protocol"
+)
+
+// morePrompt is shown between the pages of a paged result.
+const morePrompt = "-- more: space for the next page, q to stop --"
+
+// pageable reports whether query's rows should be printed a page at a time,
+// which they are when a page size is set, the session is at a terminal, and
+// the query reads rows from a database it's connected to directly.
+func (r *repl) pageable(conn *database.Connection, query string) bool {
+	return r.pageSize > 0 && conn != nil && r.remote == nil &&
+		r.output == os.Stdout &&
+		term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd())) &&
+		!strings.HasPrefix(query, localPrefix) && !database.IsWrite(query)
+}
+
+// runPaged runs query and prints its rows a page at a time, asking after each
+// page whether to go on. The query is kept open in between, so stopping early
+// doesn't read the rest of the rows. It returns the rows that were read, as
+// the result of the query.
+func (r *repl) runPaged(conn *database.Connection, query string) *protocol.QueryResult {
+	r.lastConn = conn
+	cursor, err := conn.OpenCursor(query)
+	if err != nil {
+		result := &protocol.QueryResult{Error: err.Error()}
+		r.printQueryResult(result)
+		return result
+	}
+	defer cursor.Close()
+
+	result := &protocol.QueryResult{}
+	for {
+		page := cursor.Next(r.pageSize)
+		r.printQueryResult(page)
+		if result.Columns == nil {
+			result.Columns, result.ColumnTypes = page.Columns, page.ColumnTypes
+		}
+		result.Rows = append(result.Rows, page.Rows...)
+		result.Message, result.Warnings, result.Error = page.Message, page.Warnings, page.Error
+		if cursor.Done() || page.Error != "" {
+			return result
+		}
+		if !morePages() {
+			fmt.Printf("(stopped after %d rows)\n", cursor.Read())
+			return result
+		}
+	}
+}
+
+// morePages asks whether to print the next page of a result, reading a single
+// key: space or Enter goes on, and anything else, like q, stops.
+func morePages() bool {
+	fd := int(os.Stdin.Fd())
+	fmt.Print(morePrompt)
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	key := make([]byte, 1)
+	_, err = os.Stdin.Read(key)
+	term.Restore(fd, state)
+	fmt.Print("\r\x1b[K") // clear the prompt
+	return err == nil && (key[0] == ' ' || key[0] == '\r' || key[0] == '\n')
+}
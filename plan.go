@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sqlrepl/internal/database"
+	"sqlrepl/internal/protocol"
+)
+
+// seqScanPattern matches a sequential scan node in postgres EXPLAIN output,
+// e.g. "Seq Scan on orders  (cost=0.00..1834.00 rows=100000 width=12)".
+var seqScanPattern = regexp.MustCompile(`Seq Scan on (\S+).*\brows=(\d+)`)
+
+// planWarnings looks through postgres EXPLAIN output for sequential scans
+// estimated to read more than maxRows rows, and for nested loops with no join
+// condition, which usually means an accidental cartesian join.
+func planWarnings(plan *protocol.QueryResult, maxRows int) []string {
+	var warnings []string
+	nestedLoop, joinCondition := false, false
+	for _, row := range plan.Rows {
+		if len(row.Values) == 0 {
+			continue
+		}
+		line := row.Values[0]
+
+		if match := seqScanPattern.FindStringSubmatch(line); match != nil {
+			if rows, _ := strconv.Atoi(match[2]); rows > maxRows {
+				warnings = append(warnings, fmt.Sprintf("sequential scan on %s (estimated %d rows)", match[1], rows))
+			}
+		}
+
+		switch {
+		case strings.Contains(line, "Nested Loop"):
+			nestedLoop = true
+		case strings.Contains(line, "Join Filter:"), strings.Contains(line, "Index Cond:"),
+			strings.Contains(line, "Hash Cond:"), strings.Contains(line, "Merge Cond:"):
+			joinCondition = true
+		}
+	}
+
+	if nestedLoop && !joinCondition {
+		warnings = append(warnings, "nested loop with no join condition (cartesian join?)")
+	}
+	return warnings
+}
+
+// checkPlan explains query on conn and, if the plan looks expensive, asks
+// before it is run. It reports whether the query should go ahead.
+func (r *repl) checkPlan(conn *database.Connection, query string) bool {
+	plan := conn.Explain(query)
+	if plan.Error != "" {
+		// let the query itself report the problem
+		return true
+	}
+
+	warnings := planWarnings(plan, *seqScanRows)
+	if len(warnings) == 0 {
+		return true
+	}
+	for _, warning := range warnings {
+		fmt.Println("Warning:", warning)
+	}
+	return r.confirm("Type YES to run it anyway: ")
+}
+
+// checkRowCount counts the rows an UPDATE or DELETE of one table would change
+// and, if there are more than -confirm-rows of them, asks before it is run. It
+// reports whether the query should go ahead.
+func (r *repl) checkRowCount(conn *database.Connection, query string) bool {
+	dbType := r.dbType()
+	if conn != nil {
+		dbType = conn.DBType()
+	}
+	count, ok := database.CountQuery(query, dbType)
+	if !ok {
+		return true
+	}
+
+	result := r.executeUnchecked(conn, count)
+	if result.Error != "" || len(result.Rows) != 1 || len(result.Rows[0].Values) != 1 {
+		// let the query itself report the problem
+		return true
+	}
+	rows, err := strconv.ParseInt(result.Rows[0].Values[0], 10, 64)
+	if err != nil || rows <= int64(*confirmRows) {
+		return true
+	}
+
+	fmt.Printf("Warning: this statement will change %d rows\n", rows)
+	return r.confirm("Type YES to run it anyway: ")
+}
+
+// planNode is a node of a postgres plan in EXPLAIN (FORMAT JSON) output, with
+// the fields \plan shows.
+type planNode struct {
+	NodeType     string      `json:"Node Type"`
+	RelationName string      `json:"Relation Name"`
+	Alias        string      `json:"Alias"`
+	IndexName    string      `json:"Index Name"`
+	JoinType     string      `json:"Join Type"`
+	StartupCost  float64     `json:"Startup Cost"`
+	TotalCost    float64     `json:"Total Cost"`
+	PlanRows     float64     `json:"Plan Rows"`
+	Plans        []*planNode `json:"Plans"`
+}
+
+// plan shows the plan the database would use for a query, as an indented
+// tree of its nodes with their costs and estimated rows, or with json as the
+// JSON the database describes it with. Only postgres is supported.
+// Usage: \plan [json] <query>
+func (r *repl) plan(args string) error {
+	asJSON := false
+	if rest, ok := strings.CutPrefix(args, "json "); ok {
+		asJSON, args = true, strings.TrimSpace(rest)
+	}
+	if args == "" {
+		return fmt.Errorf("usage: \\plan [json] <query>")
+	}
+
+	conn, query := r.connectionFor(substitute(args, r.vars))
+	if !database.IsExplainable(query) {
+		return fmt.Errorf("only SELECT, WITH, INSERT, UPDATE, and DELETE statements have a plan")
+	}
+	plan, err := conn.ExplainJSON(query)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		var pretty bytes.Buffer
+		if err = json.Indent(&pretty, []byte(plan), "", "  "); err != nil {
+			return fmt.Errorf("invalid plan JSON: %w", err)
+		}
+		fmt.Fprintln(r.output, pretty.String())
+		return nil
+	}
+
+	var plans []struct {
+		Plan *planNode `json:"Plan"`
+	}
+	if err = json.Unmarshal([]byte(plan), &plans); err != nil {
+		return fmt.Errorf("invalid plan JSON: %w", err)
+	}
+	var out strings.Builder
+	for _, p := range plans {
+		writePlanNode(&out, p.Plan, 0)
+	}
+	fmt.Fprint(r.output, out.String())
+	return nil
+}
+
+// writePlanNode writes a node of a plan and, indented below it, its children.
+func writePlanNode(out *strings.Builder, node *planNode, depth int) {
+	if node == nil {
+		return
+	}
+	if depth > 0 {
+		out.WriteString(strings.Repeat("   ", depth-1) + "-> ")
+	}
+
+	out.WriteString(node.NodeType)
+	if node.JoinType != "" && node.JoinType != "Inner" {
+		out.WriteString(" (" + node.JoinType + ")")
+	}
+	if node.IndexName != "" {
+		out.WriteString(" using " + node.IndexName)
+	}
+	if node.RelationName != "" {
+		out.WriteString(" on " + node.RelationName)
+		if node.Alias != "" && node.Alias != node.RelationName {
+			out.WriteString(" " + node.Alias)
+		}
+	}
+	fmt.Fprintf(out, "  cost=%.2f..%.2f rows=%.0f\n", node.StartupCost, node.TotalCost, node.PlanRows)
+
+	for _, child := range node.Plans {
+		writePlanNode(out, child, depth+1)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sqlrepl/internal/database"
+	"sqlrepl/internal/protocol"
+)
+
+// defaultQueriesFile is where named queries are kept, in the home directory,
+// when -queries doesn't name another file.
+const defaultQueriesFile = ".sqlrepl_queries.json"
+
+// queriesPath returns the file that named queries are stored in.
+func queriesPath() (string, error) {
+	if *queriesFile != "" {
+		return *queriesFile, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("can't find the home directory for the saved queries: %w", err)
+	}
+	return filepath.Join(home, defaultQueriesFile), nil
+}
+
+// loadQueries reads the named queries, a JSON object of names to queries. A
+// missing file holds no queries.
+func loadQueries(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	queries := map[string]string{}
+	if err = json.Unmarshal(data, &queries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return queries, nil
+}
+
+// storeQueries writes the named queries to path, through a temporary file so
+// that a failed write doesn't lose the ones already saved.
+func storeQueries(path string, queries map[string]string) error {
+	data, err := json.MarshalIndent(queries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// save stores a query under a name, replacing any query already saved with
+// it, to be run later with \run, in this session or another. The query may
+// refer to variables as :name, filled in when it's run.
+// Usage: \save <name> <query>
+func (r *repl) save(args string) error {
+	name, query, _ := strings.Cut(args, " ")
+	query = strings.TrimSpace(query)
+	if name == "" || query == "" {
+		return fmt.Errorf("usage: \\save <name> <query>")
+	}
+	for i := range name {
+		if !isVariableChar(name[i], i == 0) {
+			return fmt.Errorf("invalid query name: %s", name)
+		}
+	}
+
+	path, err := queriesPath()
+	if err != nil {
+		return err
+	}
+	queries, err := loadQueries(path)
+	if err != nil {
+		return err
+	}
+	queries[name] = query
+	if err = storeQueries(path, queries); err != nil {
+		return err
+	}
+	fmt.Printf("Saved query %s.\n", name)
+	return nil
+}
+
+// runSaved runs a query saved with \save. Each name=value argument sets a
+// variable for this run only, substituted for :name ahead of the session's
+// variables.
+// Usage: \run <name> [name=value ...]
+func (r *repl) runSaved(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return fmt.Errorf("usage: \\run <name> [name=value ...]")
+	}
+
+	path, err := queriesPath()
+	if err != nil {
+		return err
+	}
+	queries, err := loadQueries(path)
+	if err != nil {
+		return err
+	}
+	query, ok := queries[fields[0]]
+	if !ok {
+		return fmt.Errorf("no saved query %s", fields[0])
+	}
+
+	params := map[string]string{}
+	for _, param := range fields[1:] {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("usage: \\run <name> [name=value ...]")
+		}
+		params[name] = value
+	}
+	query = substitute(query, params)
+
+	statements := database.SplitStatements(query, r.dbType())
+	if len(statements) <= 1 {
+		statements = []string{query}
+	}
+	r.runStatements(statements)
+	return nil
+}
+
+// listQueries lists the saved queries.
+// Usage: \queries
+func (r *repl) listQueries(args string) error {
+	path, err := queriesPath()
+	if err != nil {
+		return err
+	}
+	queries, err := loadQueries(path)
+	if err != nil {
+		return err
+	}
+	if len(queries) == 0 {
+		fmt.Println("No saved queries.")
+		return nil
+	}
+
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := &protocol.QueryResult{Columns: []string{"name", "query"}}
+	for _, name := range names {
+		list.Rows = append(list.Rows, &protocol.Row{Values: []string{name, queries[name]}})
+	}
+	r.printQueryResult(list)
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"sqlrepl/internal/protocol"
+)
+
+// sessionEntry is one line of a session recording: a query as it was run,
+// with any "name>" prefix naming the connection, and unless only queries are
+// recorded, what it returned.
+type sessionEntry struct {
+	Time   time.Time       `json:"time"`
+	Query  string          `json:"query"`
+	Result *recordedResult `json:"result,omitempty"`
+}
+
+// recordedResult is a query's result as kept in a session recording.
+type recordedResult struct {
+	Columns []string   `json:"columns,omitempty"`
+	Rows    [][]string `json:"rows,omitempty"`
+	Message string     `json:"message,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+func newRecordedResult(result *protocol.QueryResult) *recordedResult {
+	recorded := &recordedResult{Columns: result.Columns, Message: result.Message, Error: result.Error}
+	for _, row := range result.Rows {
+		recorded.Rows = append(recorded.Rows, row.Values)
+	}
+	return recorded
+}
+
+// difference describes how result differs from the recorded one, or is empty
+// if they're the same. Messages aren't compared, since they can hold timings.
+func (recorded *recordedResult) difference(result *protocol.QueryResult) string {
+	switch {
+	case recorded.Error != result.Error:
+		if recorded.Error == "" {
+			return "it failed, but succeeded when recorded"
+		} else if result.Error == "" {
+			return "it succeeded, but failed when recorded"
+		}
+		return fmt.Sprintf("it failed with a different error, when recorded: %s", recorded.Error)
+	case !slices.Equal(recorded.Columns, result.Columns):
+		return fmt.Sprintf("it returned columns %v, when recorded %v", result.Columns, recorded.Columns)
+	case len(recorded.Rows) != len(result.Rows):
+		return fmt.Sprintf("it returned %d rows, when recorded %d", len(result.Rows), len(recorded.Rows))
+	}
+	for i, row := range result.Rows {
+		if !slices.Equal(recorded.Rows[i], row.Values) {
+			return fmt.Sprintf("row %d differs from when it was recorded", i+1)
+		}
+	}
+	return ""
+}
+
+// sessionRecorder appends every query run at the prompt or in a script, and
+// its result, to a file, one JSON sessionEntry per line, for -replay to run
+// again. A nil *sessionRecorder records nothing.
+type sessionRecorder struct {
+	file    *os.File
+	encoder *json.Encoder
+	results bool
+}
+
+// openRecorder opens the recording named by -record, or returns nil if there
+// isn't one.
+func openRecorder() *sessionRecorder {
+	if *recordFile == "" {
+		return nil
+	}
+	file, err := os.OpenFile(*recordFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("Error opening session recording: %v", err)
+	}
+	return &sessionRecorder{file: file, encoder: json.NewEncoder(file), results: !*recordQueries}
+}
+
+func (recorder *sessionRecorder) record(query string, result *protocol.QueryResult) {
+	if recorder == nil {
+		return
+	}
+	entry := sessionEntry{Time: time.Now(), Query: query}
+	if recorder.results {
+		entry.Result = newRecordedResult(result)
+	}
+	if err := recorder.encoder.Encode(entry); err != nil {
+		log.Printf("Error recording the session: %v", err)
+	}
+}
+
+func (recorder *sessionRecorder) Close() {
+	if recorder != nil {
+		recorder.file.Close()
+	}
+}
+
+// replay runs the queries of a session recording in turn, printing their
+// results, and warns of any that differ from what was recorded. It reports
+// whether they all ran and matched.
+func (r *repl) replay(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Error reading session recording: %v", err)
+	}
+	defer file.Close()
+
+	ok := true
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20) // recorded results can make for long lines
+	for line := 1; scanner.Scan(); line++ {
+		var entry sessionEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Fatalf("Error reading session recording: line %d: %v", line, err)
+		}
+
+		fmt.Println(r.prompt() + entry.Query)
+		result := r.runQuery(entry.Query)
+		switch {
+		case result == nil:
+			ok = false
+		case entry.Result != nil:
+			// a query that failed when recorded should fail the same way
+			if difference := entry.Result.difference(result); difference != "" {
+				fmt.Println(highlightWarning("Warning: " + difference))
+				ok = false
+			}
+		case result.Error != "":
+			ok = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Error reading session recording: %v", err)
+	}
+	return ok
+}
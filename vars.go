@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// substitute replaces :name in query with the value of variable name, and
+// :'name' with the value quoted as a string literal. References to unknown
+// variables are left alone, as are quoted strings and postgres :: casts.
+func substitute(query string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return query
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				out.WriteString(query[i:])
+				return out.String()
+			}
+			out.WriteString(query[i : i+end+2])
+			i += end + 1
+
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			out.WriteString("::")
+			i++
+
+		case c == ':' && i+1 < len(query):
+			quoted := query[i+1] == '\''
+			start := i + 1
+			if quoted {
+				start++
+			}
+			end := start
+			for end < len(query) && isVariableChar(query[end], end == start) {
+				end++
+			}
+			if quoted && (end >= len(query) || query[end] != '\'') {
+				out.WriteByte(c)
+				continue
+			}
+
+			value, ok := vars[query[start:end]]
+			if !ok || end == start {
+				out.WriteByte(c)
+				continue
+			}
+			if quoted {
+				out.WriteString("'" + strings.ReplaceAll(value, "'", "''") + "'")
+				end++
+			} else {
+				out.WriteString(value)
+			}
+			i = end - 1
+
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+func isVariableChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// loadVars reads variables from a JSON object of names to scalar values.
+func loadVars(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err = decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	vars := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch value := value.(type) {
+		case string:
+			vars[name] = value
+		case json.Number, bool:
+			vars[name] = fmt.Sprint(value)
+		case nil:
+			vars[name] = "NULL"
+		default:
+			return nil, fmt.Errorf("variable %s in %s must be a string, number, boolean, or null", name, path)
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sqlrepl/internal/protocol"
+)
+
+// defaultWatchInterval is how often \watch runs its query unless told
+// otherwise.
+const defaultWatchInterval = 2 * time.Second
+
+// ANSI escape codes for the rows \watch highlights
+const (
+	addedStyle   = "\x1b[32m"   // green
+	changedStyle = "\x1b[7m"    // reversed
+	removedStyle = "\x1b[31;9m" // red, struck through
+)
+
+// watch runs a query over and over, printing each result, until interrupted
+// with Ctrl+C or the query fails. In the table format the rows added since
+// the last run, the values changed, and the rows removed are highlighted.
+// The interval is a duration like 500ms or a number of seconds.
+// Usage: \watch [interval] <query>
+func (r *repl) watch(args string) error {
+	interval := defaultWatchInterval
+	first, rest, _ := strings.Cut(args, " ")
+	if duration, err := time.ParseDuration(first); err == nil {
+		interval, args = duration, rest
+	} else if seconds, err := strconv.ParseFloat(first, 64); err == nil {
+		interval, args = time.Duration(seconds*float64(time.Second)), rest
+	}
+	query := substitute(strings.TrimSpace(args), r.vars)
+	if query == "" || interval <= 0 {
+		return fmt.Errorf("usage: \\watch [interval] <query>")
+	}
+
+	// the query is allowed once, rather than asked about on every run
+	conn, statement := r.connectionFor(query)
+	if !r.allowed(conn, statement) {
+		return errNotRun
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// previous is the last result as it was shown, which the next is
+	// compared with
+	var previous *protocol.QueryResult
+	color := colorWarnings && r.output == os.Stdout
+	for {
+		fmt.Fprintf(r.output, "%s (every %s): %s\n\n", time.Now().Format(time.TimeOnly), interval, query)
+		result := r.executeUnchecked(conn, statement)
+		r.lastResult = result
+		r.printResult(result, func(w io.Writer, display *protocol.QueryResult) {
+			changes := diffResults(previous, display)
+			previous = display
+			if !color {
+				writeTable(w, display, r.border)
+			} else {
+				writeStyledTable(w, changes.withRemoved(display), r.border, changes.style)
+			}
+			if summary := changes.String(); summary != "" {
+				fmt.Fprintln(w, summary)
+			}
+		})
+		if result.Error != "" {
+			return nil
+		}
+		fmt.Fprintln(r.output)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// resultChanges is how the rows of a result differ from the last one's.
+type resultChanges struct {
+	// added are the rows, by index, that weren't in the last result
+	added map[int]bool
+
+	// changed are the columns, by row index, whose values changed
+	changed map[int]map[int]bool
+
+	// removed are the rows of the last result that are gone
+	removed []*protocol.Row
+
+	// rows is how many rows the result has, which removed rows follow
+	rows int
+}
+
+// diffResults compares a result with the one before it. Rows are matched by
+// the value in their first column if it's unique in both, like an id, and
+// otherwise by position. There are no changes if there's no previous result
+// to compare, or its columns were different.
+func diffResults(previous, current *protocol.QueryResult) resultChanges {
+	changes := resultChanges{added: map[int]bool{}, changed: map[int]map[int]bool{}, rows: len(current.Rows)}
+	if previous == nil || !slices.Equal(previous.Columns, current.Columns) {
+		return changes
+	}
+
+	key := func(n int, row *protocol.Row) string { return strconv.Itoa(n) }
+	if uniqueFirstValues(previous) && uniqueFirstValues(current) {
+		key = func(n int, row *protocol.Row) string { return row.Values[0] }
+	}
+
+	before := map[string]*protocol.Row{}
+	for n, row := range previous.Rows {
+		before[key(n, row)] = row
+	}
+	seen := map[string]bool{}
+	for n, row := range current.Rows {
+		k := key(n, row)
+		seen[k] = true
+		old, ok := before[k]
+		if !ok {
+			changes.added[n] = true
+			continue
+		}
+		for i, value := range row.Values {
+			if i >= len(old.Values) || old.Values[i] != value {
+				if changes.changed[n] == nil {
+					changes.changed[n] = map[int]bool{}
+				}
+				changes.changed[n][i] = true
+			}
+		}
+	}
+	for n, row := range previous.Rows {
+		if !seen[key(n, row)] {
+			changes.removed = append(changes.removed, row)
+		}
+	}
+	return changes
+}
+
+// uniqueFirstValues reports whether no two rows of result have the same value
+// in the first column.
+func uniqueFirstValues(result *protocol.QueryResult) bool {
+	values := map[string]bool{}
+	for _, row := range result.Rows {
+		if len(row.Values) == 0 || values[row.Values[0]] {
+			return false
+		}
+		values[row.Values[0]] = true
+	}
+	return true
+}
+
+// withRemoved returns a copy of result with the removed rows after its own,
+// to be shown struck through.
+func (changes resultChanges) withRemoved(result *protocol.QueryResult) *protocol.QueryResult {
+	if len(changes.removed) == 0 {
+		return result
+	}
+	return &protocol.QueryResult{
+		Columns:     result.Columns,
+		ColumnTypes: result.ColumnTypes,
+		Rows:        append(append([]*protocol.Row{}, result.Rows...), changes.removed...),
+	}
+}
+
+// style returns the ANSI escape code that a value is highlighted with, if
+// it's in a row that was added or removed or it changed.
+func (changes resultChanges) style(row, col int) string {
+	switch {
+	case row >= changes.rows:
+		return removedStyle
+	case changes.added[row]:
+		return addedStyle
+	case changes.changed[row][col]:
+		return changedStyle
+	}
+	return ""
+}
+
+// String summarizes the changes, such as "(1 added, 2 changed since the last
+// run)", or is empty if there are none.
+func (changes resultChanges) String() string {
+	var counts []string
+	if n := len(changes.added); n > 0 {
+		counts = append(counts, fmt.Sprintf("%d added", n))
+	}
+	if n := len(changes.changed); n > 0 {
+		counts = append(counts, fmt.Sprintf("%d changed", n))
+	}
+	if n := len(changes.removed); n > 0 {
+		counts = append(counts, fmt.Sprintf("%d removed", n))
+	}
+	if len(counts) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(counts, ", ") + " since the last run)"
+}